@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// --- AUTH ---
+// Админы хранятся в таблице users (bcrypt), сессии — в таблице sessions.
+// В cookie лежит случайный токен, в БД — только его sha256.
+//
+// Вход ограничен (rateLimiter из spam.go): LOGIN_RATE_LIMIT_IP=20 попыток с
+// адреса и LOGIN_RATE_LIMIT_LOGIN=5 неудачных подряд на логин за
+// LOGIN_RATE_WINDOW=15m, сверх — 429. Для несуществующего логина bcrypt
+// всё равно выполняется, чтобы по времени ответа нельзя было узнать логины.
+
+const (
+	sessionCookieName = "influence_session"
+	sessionTTL        = 7 * 24 * time.Hour
+)
+
+type User struct {
+	ID         int    `json:"id"`
+	Login      string `json:"login"`
+	Role       string `json:"role"`
+	TelegramID int64  `json:"telegram_id,omitempty"` // для кнопок в уведомлениях (telegram_actions.go)
+}
+
+type LoginRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+var (
+	loginIPLimiter   = newRateLimiter(20, 15*time.Minute)
+	loginUserLimiter = newRateLimiter(5, 15*time.Minute)
+)
+
+// initLoginRateLimit читает лимиты входа из env.
+func initLoginRateLimit() {
+	window := envDuration("LOGIN_RATE_WINDOW", 15*time.Minute)
+	loginIPLimiter = newRateLimiter(envInt("LOGIN_RATE_LIMIT_IP", 20), window)
+	loginUserLimiter = newRateLimiter(envInt("LOGIN_RATE_LIMIT_LOGIN", 5), window)
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash — хэш для сравнения, когда логина нет.
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+type ctxKey int
+
+const userCtxKey ctxKey = iota
+
+// seedAdmin создаёт первого администратора из ADMIN_LOGIN/ADMIN_PASSWORD,
+// если таблица users пуста.
+func seedAdmin() {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		log.Fatal(err)
+	}
+	if count > 0 {
+		return
+	}
+	login := strings.TrimSpace(os.Getenv("ADMIN_LOGIN"))
+	password := os.Getenv("ADMIN_PASSWORD")
+	if login == "" || password == "" {
+		log.Println("Warning: no admin users and ADMIN_LOGIN/ADMIN_PASSWORD not set, admin API is locked")
+		return
+	}
+	if _, err := createUser(login, password, roleAdmin); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("[auth] Created admin user %q", login)
+}
+
+func createUser(login, password, role string) (int, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+	res, err := db.Exec("INSERT INTO users (login, password_hash, role, created_at) VALUES (?, ?, ?, ?)", login, string(hash), role, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	return int(id), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSession(userID int) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(buf)
+	expires := time.Now().Add(sessionTTL)
+	_, err := db.Exec("INSERT INTO sessions (token_hash, user_id, expires_at, created_at) VALUES (?, ?, ?, ?)", hashToken(token), userID, expires.Unix(), time.Now().Unix())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// sessionUser возвращает пользователя по cookie сессии или nil.
+func sessionUser(r *http.Request) *User {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil || c.Value == "" {
+		return nil
+	}
+	var u User
+	err = db.QueryRow(`SELECT u.id, u.login, u.role FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?`, hashToken(c.Value), time.Now().Unix()).Scan(&u.ID, &u.Login, &u.Role)
+	if err != nil {
+		return nil
+	}
+	return &u
+}
+
+func currentUser(r *http.Request) *User {
+	u, _ := r.Context().Value(userCtxKey).(*User)
+	return u
+}
+
+// cookieSecure позволяет отключить флаг Secure для локальной разработки по http.
+func cookieSecure() bool {
+	return os.Getenv("COOKIE_INSECURE") != "1"
+}
+
+func setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+		HttpOnly: true,
+		Secure:   cookieSecure(),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cookieSecure(),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// withAuth требует валидную сессию для любого метода.
+func withAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := sessionUser(r)
+		if u == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), userCtxKey, u)))
+	}
+}
+
+// withAuthWrites пропускает GET/HEAD без сессии, а POST/PUT/DELETE — только с ней.
+func withAuthWrites(h http.HandlerFunc) http.HandlerFunc {
+	authed := withAuth(h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			h(w, r)
+			return
+		}
+		authed(w, r)
+	}
+}
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	login := strings.TrimSpace(req.Login)
+	loginKey := strings.ToLower(login)
+	now := time.Now()
+	ok, wait := loginIPLimiter.allow(clientIP(r), now)
+	if ok {
+		ok, wait = loginUserLimiter.allow(loginKey, now)
+	}
+	if !ok {
+		log.Printf("[auth] login rate limit: ip %s login %q", clientIP(r), login)
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+	var u User
+	var hash string
+	err := db.QueryRow("SELECT id, login, role, password_hash FROM users WHERE login = ?", login).Scan(&u.ID, &u.Login, &u.Role, &hash)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if err == sql.ErrNoRows {
+		// Столько же работы, сколько для существующего логина
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(req.Password))
+		http.Error(w, "Invalid login or password", http.StatusUnauthorized)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+		http.Error(w, "Invalid login or password", http.StatusUnauthorized)
+		return
+	}
+	// Лимит на логин считает неудачи подряд
+	loginUserLimiter.reset(loginKey)
+	token, expires, err := newSession(u.ID)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	// Заодно чистим протухшие сессии
+	_, _ = db.Exec("DELETE FROM sessions WHERE expires_at <= ?", time.Now().Unix())
+	setSessionCookie(w, token, expires)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c, err := r.Cookie(sessionCookieName); err == nil && c.Value != "" {
+		_, _ = db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(c.Value))
+	}
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	u := currentUser(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":          u.ID,
+		"login":       u.Login,
+		"role":        u.Role,
+		"permissions": userPermissions(u),
+	})
+}
+
+// --- USERS (admin only) ---
+type UserRequest struct {
+	Login      string `json:"login"`
+	Password   string `json:"password"`
+	Role       string `json:"role"`
+	TelegramID *int64 `json:"telegram_id"` // 0 — отвязать
+}
+
+func handleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query("SELECT id, login, role, IFNULL(telegram_id, 0) FROM users ORDER BY id")
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		users := []User{}
+		for rows.Next() {
+			var u User
+			if err := rows.Scan(&u.ID, &u.Login, &u.Role, &u.TelegramID); err == nil {
+				users = append(users, u)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(users)
+	case http.MethodPost:
+		var req UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		req.Login = strings.TrimSpace(req.Login)
+		if req.Login == "" || req.Password == "" {
+			http.Error(w, "Login and password are required", http.StatusBadRequest)
+			return
+		}
+		if !validRole(req.Role) {
+			http.Error(w, "Unknown role", http.StatusBadRequest)
+			return
+		}
+		id, err := createUser(req.Login, req.Password, req.Role)
+		if err != nil {
+			http.Error(w, "User already exists", http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(User{ID: id, Login: req.Login, Role: req.Role})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleUserByID(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	if id == "" {
+		http.Error(w, "Missing id", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		var req UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Role != "" {
+			if !validRole(req.Role) {
+				http.Error(w, "Unknown role", http.StatusBadRequest)
+				return
+			}
+			if _, err := db.Exec("UPDATE users SET role=? WHERE id=?", req.Role, id); err != nil {
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if req.TelegramID != nil {
+			var tgID interface{}
+			if *req.TelegramID != 0 {
+				tgID = *req.TelegramID
+			}
+			if _, err := db.Exec("UPDATE users SET telegram_id=? WHERE id=?", tgID, id); err != nil {
+				http.Error(w, "Telegram account is linked to another user", http.StatusConflict)
+				return
+			}
+		}
+		if req.Password != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				http.Error(w, "Hash error", http.StatusInternalServerError)
+				return
+			}
+			if _, err := db.Exec("UPDATE users SET password_hash=? WHERE id=?", string(hash), id); err != nil {
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			// Смена пароля разлогинивает все сессии пользователя
+			_, _ = db.Exec("DELETE FROM sessions WHERE user_id=?", id)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"ok"}`))
+	case http.MethodDelete:
+		if fmt.Sprint(currentUser(r).ID) == id {
+			http.Error(w, "Cannot delete yourself", http.StatusBadRequest)
+			return
+		}
+		if _, err := db.Exec("DELETE FROM users WHERE id=?", id); err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"ok"}`))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postLogin(login, password, ip string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/login",
+		strings.NewReader(`{"login":"`+login+`","password":"`+password+`"}`))
+	r.RemoteAddr = ip + ":40000"
+	w := httptest.NewRecorder()
+	handleLogin(w, r)
+	return w
+}
+
+// setupLoginLimits — свежие лимиты входа на время теста.
+func setupLoginLimits(t *testing.T, perIP, perLogin string) {
+	t.Helper()
+	prevIP, prevUser := loginIPLimiter, loginUserLimiter
+	t.Setenv("LOGIN_RATE_LIMIT_IP", perIP)
+	t.Setenv("LOGIN_RATE_LIMIT_LOGIN", perLogin)
+	initLoginRateLimit()
+	t.Cleanup(func() { loginIPLimiter, loginUserLimiter = prevIP, prevUser })
+}
+
+func sessionCookie(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestLoginSessionCookie(t *testing.T) {
+	setupTestDB(t)
+	setupLoginLimits(t, "0", "0")
+	if _, err := createUser("admin", "secret123", roleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, login, password string
+		want                  int
+	}{
+		{"wrong password", "admin", "nope", http.StatusUnauthorized},
+		{"unknown login", "ghost", "secret123", http.StatusUnauthorized},
+		{"empty", "", "", http.StatusUnauthorized},
+	} {
+		w := postLogin(tc.login, tc.password, "192.0.2.1")
+		if w.Code != tc.want || sessionCookie(t, w) != nil {
+			t.Errorf("%s: status %d, cookie set %v", tc.name, w.Code, sessionCookie(t, w) != nil)
+		}
+	}
+
+	for _, insecure := range []bool{false, true} {
+		if insecure {
+			t.Setenv("COOKIE_INSECURE", "1")
+		}
+		w := postLogin(" admin ", "secret123", "192.0.2.1")
+		if w.Code != http.StatusOK {
+			t.Fatalf("login: status %d: %s", w.Code, w.Body)
+		}
+		c := sessionCookie(t, w)
+		if c == nil {
+			t.Fatal("no session cookie")
+		}
+		if !c.HttpOnly || c.SameSite != http.SameSiteStrictMode || c.Path != "/" || c.Secure == insecure {
+			t.Errorf("cookie flags %+v (COOKIE_INSECURE=%v)", c, insecure)
+		}
+		if b, err := hex.DecodeString(c.Value); err != nil || len(b) != 32 {
+			t.Errorf("token %q is not 32 random bytes", c.Value)
+		}
+		if c.MaxAge < int((sessionTTL - time.Minute).Seconds()) {
+			t.Errorf("MaxAge %d", c.MaxAge)
+		}
+		// В БД только хэш токена
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM sessions WHERE token_hash = ?", c.Value).Scan(&n)
+		if n != 0 {
+			t.Error("raw token stored in sessions")
+		}
+		db.QueryRow("SELECT COUNT(*) FROM sessions WHERE token_hash = ?", hashToken(c.Value)).Scan(&n)
+		if n != 1 {
+			t.Error("session hash not stored")
+		}
+	}
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	setupTestDB(t)
+	setupLoginLimits(t, "6", "3")
+	if _, err := createUser("admin", "secret123", roleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Удачный вход сбрасывает счётчик неудач по логину
+	postLogin("admin", "bad", "192.0.2.1")
+	postLogin("admin", "bad", "192.0.2.1")
+	if w := postLogin("admin", "secret123", "192.0.2.1"); w.Code != http.StatusOK {
+		t.Fatalf("login: status %d", w.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if w := postLogin("Admin", "bad", "192.0.2.2"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d", i+1, w.Code)
+		}
+	}
+	// Логин заблокирован с любого адреса, даже с верным паролем
+	w := postLogin("admin", "secret123", "192.0.2.3")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("locked login: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	// Лимит по адресу: 6 попыток с 192.0.2.1 — дальше 429 для любого логина
+	postLogin("a", "x", "192.0.2.1")
+	postLogin("b", "x", "192.0.2.1")
+	postLogin("c", "x", "192.0.2.1")
+	if w := postLogin("d", "x", "192.0.2.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("ip limit: status %d", w.Code)
+	}
+	if w := postLogin("d", "x", "192.0.2.4"); w.Code != http.StatusUnauthorized {
+		t.Errorf("other ip: status %d", w.Code)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	setupTestDB(t)
+	id, err := createUser("editor", "secret123", roleEditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := newSession(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _, err := newSession(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("UPDATE sessions SET expires_at = ? WHERE token_hash = ?", time.Now().Add(-time.Minute).Unix(), hashToken(expired))
+
+	var seen *User
+	h := func(w http.ResponseWriter, r *http.Request) {
+		seen = currentUser(r)
+		w.WriteHeader(http.StatusOK)
+	}
+	for _, tc := range []struct {
+		name   string
+		wrap   func(http.HandlerFunc) http.HandlerFunc
+		method string
+		cookie string
+		want   int
+		user   bool
+	}{
+		{"no cookie", withAuth, http.MethodGet, "", http.StatusUnauthorized, false},
+		{"unknown token", withAuth, http.MethodGet, "deadbeef", http.StatusUnauthorized, false},
+		{"token hash as cookie", withAuth, http.MethodGet, hashToken(token), http.StatusUnauthorized, false},
+		{"expired", withAuth, http.MethodGet, expired, http.StatusUnauthorized, false},
+		{"valid", withAuth, http.MethodGet, token, http.StatusOK, true},
+		{"public read", withAuthWrites, http.MethodGet, "", http.StatusOK, false},
+		{"public head", withAuthWrites, http.MethodHead, "", http.StatusOK, false},
+		{"anonymous write", withAuthWrites, http.MethodPost, "", http.StatusUnauthorized, false},
+		{"anonymous delete", withAuthWrites, http.MethodDelete, "", http.StatusUnauthorized, false},
+		{"signed-in write", withAuthWrites, http.MethodPut, token, http.StatusOK, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			seen = nil
+			r := httptest.NewRequest(tc.method, "/api/blog", nil)
+			if tc.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tc.cookie})
+			}
+			w := httptest.NewRecorder()
+			tc.wrap(h)(w, r)
+			if w.Code != tc.want {
+				t.Errorf("status %d, want %d", w.Code, tc.want)
+			}
+			if (seen != nil) != tc.user || (seen != nil && (seen.ID != id || seen.Role != roleEditor)) {
+				t.Errorf("current user %+v", seen)
+			}
+		})
+	}
+
+	// После выхода токен больше не действует
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	w := httptest.NewRecorder()
+	handleLogout(w, r)
+	if c := sessionCookie(t, w); c == nil || c.MaxAge >= 0 {
+		t.Errorf("logout cookie %+v", c)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	if sessionUser(r) != nil {
+		t.Error("session still valid after logout")
+	}
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// --- CONTENT TYPES ---
+// Декларативный реестр сущностей сайта (blog, projects, led...).
+// У каждой сущности есть таблица с колонками id, img (обложка), images (JSON TEXT)
+// и набор полей из схемы. CRUD-обработчики и репозиторий общие для всех типов,
+// поэтому новая сущность — это одна запись в contentTypes плюс миграция
+// с CREATE TABLE в migrations.go.
+
+type FieldKind int
+
+const (
+	FieldText FieldKind = iota // TEXT колонка, строка в JSON
+	FieldList                  // JSON-массив строк в TEXT колонке
+)
+
+type Field struct {
+	Name         string // имя колонки и ключ в JSON
+	Kind         FieldKind
+	MaxItems     int    // для FieldList
+	Translatable bool   // *_uz / *_en, см. handleTranslationUpdate
+	SlugOf       string // slug, строящийся из этого поля (см. slugs.go); пишется только syncSlugs
+}
+
+type ContentType struct {
+	Name        string // сегмент URL: /api/{Name}
+	Table       string
+	Page        string // публичная страница записи, открывается с ?id=
+	SSR         bool   // Page — шаблон, рендерится сервером (ssr.go)
+	Fields      []Field
+	MaxImages   int
+	MaxFormSize int64 // лимит на весь запрос; на файл — maxUploadFileSize
+}
+
+// localized разворачивает title -> title, title_uz, title_en.
+func localized(names ...string) []Field {
+	var out []Field
+	for _, n := range names {
+		out = append(out,
+			Field{Name: n},
+			Field{Name: n + "_uz", Translatable: true},
+			Field{Name: n + "_en", Translatable: true},
+		)
+	}
+	return out
+}
+
+// localizedSlugs добавляет slug, slug_uz, slug_en для заголовка name.
+func localizedSlugs(name string) []Field {
+	return []Field{
+		{Name: "slug", SlugOf: name},
+		{Name: "slug_uz", SlugOf: name + "_uz"},
+		{Name: "slug_en", SlugOf: name + "_en"},
+	}
+}
+
+func withFields(base []Field, extra ...Field) []Field {
+	return append(append([]Field{}, base...), extra...)
+}
+
+var contentTypes = []*ContentType{
+	{
+		Name:        "blog",
+		Table:       "blog",
+		Page:        "blog-post.html",
+		SSR:         true,
+		Fields:      withFields(withFields(localized("title", "description"), Field{Name: "links", Kind: FieldList, MaxItems: 5}), localizedSlugs("title")...),
+		MaxImages:   10,
+		MaxFormSize: 40 << 20,
+	},
+	{
+		Name:        "projects",
+		Table:       "projects",
+		Page:        "project-detail.html",
+		SSR:         true,
+		Fields:      withFields(withFields(localized("title", "description"), Field{Name: "links", Kind: FieldList, MaxItems: 5}), localizedSlugs("title")...),
+		MaxImages:   10,
+		MaxFormSize: 40 << 20,
+	},
+	{
+		Name:        "led",
+		Table:       "led",
+		Page:        "led.html",
+		Fields:      withFields(localized("title", "description"), Field{Name: "location"}),
+		MaxImages:   10,
+		MaxFormSize: 40 << 20,
+	},
+}
+
+func contentTypeByName(name string) *ContentType {
+	for _, ct := range contentTypes {
+		if ct.Name == name {
+			return ct
+		}
+	}
+	return nil
+}
+
+func contentTypeByTable(table string) *ContentType {
+	for _, ct := range contentTypes {
+		if ct.Table == table {
+			return ct
+		}
+	}
+	return nil
+}
+
+// pageURL — публичный адрес записи: /{type}/{slug} для типов со slug'ами,
+// иначе /{page}?id=N.
+func (ct *ContentType) pageURL(id int, lang string) string {
+	if ct.hasSlugs() {
+		if cur, err := ct.currentSlugs(db, id); err == nil {
+			if slug := cur[lang]; slug != "" {
+				return "/" + ct.Name + "/" + slug
+			}
+			if slug := cur["ru"]; slug != "" {
+				return "/" + ct.Name + "/" + slug
+			}
+		}
+	}
+	return fmt.Sprintf("/%s?id=%d", ct.Page, id)
+}
+
+func (ct *ContentType) field(name string) (Field, bool) {
+	for _, f := range ct.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// registerContentRoutes вешает /api/{type} и /api/{type}/ для всех типов из реестра.
+func registerContentRoutes() {
+	for _, ct := range contentTypes {
+		http.HandleFunc("/api/"+ct.Name, withCORS(withAuthWrites(ct.handleCollection)))
+		http.HandleFunc("/api/"+ct.Name+"/", withCORS(withAuthWrites(ct.handleItem)))
+	}
+}
+
+// Item — одна запись любого типа. Values хранит string для FieldText
+// и []string для FieldList.
+type Item struct {
+	ID        int
+	Img       string
+	Images    []string
+	ImageMeta map[string]*ImageMeta // alt, подписи и фокус по URL из Images, см. image_meta.go
+	Values    map[string]interface{}
+	CreatedAt int64 // unix-время, ставится триггерами (migrations.go)
+	UpdatedAt int64
+	// ImageInfos — варианты для srcset по индексам Images, см. attachImageInfos
+	ImageInfos []*ImageInfo
+	ct         *ContentType
+}
+
+func (ct *ContentType) newItem() *Item {
+	return &Item{ct: ct, Values: map[string]interface{}{}, ImageMeta: map[string]*ImageMeta{}}
+}
+
+func (it *Item) Text(name string) string {
+	s, _ := it.Values[name].(string)
+	return s
+}
+
+func (it *Item) List(name string) []string {
+	l, _ := it.Values[name].([]string)
+	return l
+}
+
+// setImages обрезает список до лимита типа и выставляет обложку.
+func (it *Item) setImages(images []string) {
+	it.Images = clampStrings(images, it.ct.MaxImages)
+	it.Img = ""
+	if len(it.Images) > 0 {
+		it.Img = it.Images[0]
+	}
+}
+
+// MarshalJSON сохраняет порядок полей из схемы: id, img, images,
+// image_variants (если подгружены), поля типа, затем created_at/updated_at.
+func (it *Item) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	write := func(key string, v interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(b)
+		return nil
+	}
+	buf.WriteByte('{')
+	if err := write("id", it.ID); err != nil {
+		return nil, err
+	}
+	if err := write("img", it.Img); err != nil {
+		return nil, err
+	}
+	if err := write("images", nonNil(it.Images)); err != nil {
+		return nil, err
+	}
+	if err := write("image_details", it.imageDetails()); err != nil {
+		return nil, err
+	}
+	if it.ImageInfos != nil {
+		if err := write("image_variants", it.ImageInfos); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range it.ct.Fields {
+		var v interface{} = it.Text(f.Name)
+		if f.Kind == FieldList {
+			v = nonNil(it.List(f.Name))
+		}
+		if err := write(f.Name, v); err != nil {
+			return nil, err
+		}
+	}
+	if err := write("created_at", it.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := write("updated_at", it.UpdatedAt); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func nonNil(arr []string) []string {
+	if arr == nil {
+		return []string{}
+	}
+	return arr
+}
+
+// --- CONTENT CRUD ---
+func (ct *ContentType) handleCollection(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		lq, err := ct.parseListQuery(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		items, total, next, err := ct.queryItems(lq)
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		attachImageInfos(items...)
+		w.Header().Set("Content-Type", "application/json")
+		if !wantsEnvelope(q) {
+			// Старый формат: голый массив
+			json.NewEncoder(w).Encode(items)
+			return
+		}
+		json.NewEncoder(w).Encode(ListResponse{Items: items, Total: total, Limit: lq.Limit, Offset: lq.Offset, NextCursor: next})
+	case http.MethodPost:
+		if !requirePermission(w, r, permContentCreate) {
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, ct.MaxFormSize)
+		var it *Item
+		var err error
+		if isMultipart(r) {
+			it, err = ct.itemFromForm(r, nil)
+		} else {
+			it, err = ct.itemFromJSON(r)
+		}
+		if err != nil {
+			writeRequestError(w, err)
+			return
+		}
+		if err := ct.insertItem(it); err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if err := ct.syncSlugs(db, it); err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if err := ct.syncMediaRefs(db, it); err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		contentChanged(ct)
+		// Отдаём сохранённую строку: created_at/updated_at ставит БД
+		stored, err := ct.getItem(strconv.Itoa(it.ID))
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		attachImageInfos(stored)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stored)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (ct *ContentType) handleItem(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/"+ct.Name+"/")
+	if id == "" {
+		http.Error(w, "Missing id", http.StatusBadRequest)
+		return
+	}
+	if slug, ok := strings.CutPrefix(id, "by-slug/"); ok && ct.hasSlugs() {
+		ct.handleBySlug(w, r, slug)
+		return
+	}
+	if itemID, sub, ok := strings.Cut(id, "/"); ok {
+		ct.handleItemImages(w, r, itemID, sub)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		it, err := ct.getItem(id)
+		if err != nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		attachImageInfos(it)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(it)
+	case http.MethodPost, http.MethodPut:
+		r.Body = http.MaxBytesReader(w, r.Body, ct.MaxFormSize)
+		// Текущая версия нужна для картинок: при отсутствии imagesOld список берётся
+		// из неё, а подписи сохраняются для картинок, пришедших строками. Её же
+		// отсутствие — 404 до разбора формы, то есть до сохранения файлов
+		cur, err := ct.getItem(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if !hasPermission(currentUser(r), permContentEdit) {
+			// Переводчик может менять только *_uz/*_en поля
+			if requirePermission(w, r, permContentTranslate) {
+				handleTranslationUpdate(w, r, ct, id)
+			}
+			return
+		}
+		var it *Item
+		if isMultipart(r) {
+			it, err = ct.itemFromForm(r, cur.Images)
+		} else {
+			it, err = ct.itemFromJSON(r)
+		}
+		if err != nil {
+			writeRequestError(w, err)
+			return
+		}
+		it.keepImageMeta(cur)
+		if err := ct.updateItem(id, it); errors.Is(err, sql.ErrNoRows) {
+			// Запись удалили, пока разбиралась форма
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if err := ct.syncSlugs(db, it); err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if err := ct.syncMediaRefs(db, it); err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		contentChanged(ct)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"ok"}`))
+	case http.MethodDelete:
+		if !requirePermission(w, r, permContentDelete) {
+			return
+		}
+		if err := ct.deleteItem(id); err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		contentChanged(ct)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"ok"}`))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type ListResponse struct {
+	Items      []*Item `json:"items"`
+	Total      int     `json:"total"`
+	Limit      int     `json:"limit,omitempty"`
+	Offset     int     `json:"offset,omitempty"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// wantsEnvelope: конверт отдаётся при пагинации или явном envelope=1,
+// иначе — голый массив, как раньше.
+func wantsEnvelope(q url.Values) bool {
+	if v := q.Get("envelope"); v != "" {
+		return v == "1" || v == "true"
+	}
+	return q.Has("limit") || q.Has("offset") || q.Has("cursor")
+}
+
+func isMultipart(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data")
+}
+
+type badRequest string
+
+func (e badRequest) Error() string { return string(e) }
+
+// itemFromForm собирает запись из multipart-формы админки. fallbackImages
+// используются при редактировании, если поля imagesOld в форме нет. Файлы
+// сначала проверяются все (uploads.go) и сохраняются, только если подходят все.
+func (ct *ContentType) itemFromForm(r *http.Request, fallbackImages []string) (*Item, error) {
+	if err := parseUploadForm(r); err != nil {
+		return nil, err
+	}
+	it := ct.newItem()
+	for _, f := range ct.Fields {
+		switch f.Kind {
+		case FieldList:
+			it.Values[f.Name] = parseListFromForm(r, f.Name, f.MaxItems)
+		default:
+			it.Values[f.Name] = r.FormValue(f.Name)
+		}
+	}
+	// Пустой imagesOld ("" или "[]") — явная очистка; fallback только без поля
+	var images []string
+	var err error
+	if _, ok := r.MultipartForm.Value["imagesOld"]; ok {
+		if oldJSON := strings.TrimSpace(r.FormValue("imagesOld")); oldJSON != "" {
+			if images, it.ImageMeta, err = parseImagesJSON([]byte(oldJSON)); err != nil {
+				return nil, imagesError(err, "Invalid imagesOld")
+			}
+		}
+	} else {
+		images = append(images, fallbackImages...)
+	}
+	added, err := checkFormUploads(r, "imgs", ct.MaxImages)
+	if err != nil {
+		return nil, err
+	}
+	// Одиночная картинка "img" — новая обложка
+	cover, err := checkFormUploads(r, "img", 1)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := saveUploads(append(cover, added...))
+	if err != nil {
+		log.Println("Upload error:", err)
+		return nil, &uploadError{Status: http.StatusInternalServerError, Message: "Upload error"}
+	}
+	// Новые файлы — в конец, новая обложка — первой
+	images = append(images, paths[len(cover):]...)
+	images = append(paths[:len(cover):len(cover)], images...)
+	it.setImages(images)
+	return it, nil
+}
+
+func (ct *ContentType) itemFromJSON(r *http.Request) (*Item, error) {
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, badRequest("Invalid JSON")
+	}
+	it := ct.newItem()
+	for _, f := range ct.Fields {
+		raw, ok := body[f.Name]
+		switch f.Kind {
+		case FieldList:
+			var arr []string
+			if ok {
+				if err := json.Unmarshal(raw, &arr); err != nil {
+					return nil, badRequest("Invalid JSON: " + f.Name)
+				}
+			}
+			it.Values[f.Name] = clampStrings(uniqueStrings(arr), f.MaxItems)
+		default:
+			var s string
+			if ok {
+				if err := json.Unmarshal(raw, &s); err != nil {
+					return nil, badRequest("Invalid JSON: " + f.Name)
+				}
+			}
+			it.Values[f.Name] = s
+		}
+	}
+	// images — массив URL или объектов с подписями (image_meta.go)
+	var images []string
+	if raw, ok := body["images"]; ok {
+		var err error
+		if images, it.ImageMeta, err = parseImagesJSON(raw); err != nil {
+			return nil, imagesError(err, "Invalid JSON: images")
+		}
+	}
+	it.setImages(images)
+	return it, nil
+}
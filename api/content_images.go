@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+)
+
+// --- CONTENT IMAGES ---
+// Точечная работа с картинками записи без пересылки всей формы:
+//
+//	POST   /api/{type}/{id}/images        multipart: imgs — в конец, img — новой обложкой
+//	DELETE /api/{type}/{id}/images?url=…  убрать картинки (url повторяется); ?all=1 — все
+//	PUT    /api/{type}/{id}/images/order  {"images": [...]} — тот же набор в новом порядке
+//	PUT    /api/{type}/{id}/images/cover  {"url": "..."} — сделать обложкой
+//	PUT    /api/{type}/{id}/images/meta   {"url": "...", "alt_ru": "...", ...} или массив таких
+//	                                      объектов — заменить alt/подписи/фокус (image_meta.go)
+//
+// Обложка (колонка img) — всегда первая картинка, см. setImages. Убранные файлы
+// не удаляются сразу: их подберёт GC (media.go), если на них больше никто не ссылается.
+// Ответ — запись целиком, как GET /api/{type}/{id}.
+
+func (ct *ContentType) handleItemImages(w http.ResponseWriter, r *http.Request, id, sub string) {
+	var method string
+	switch sub {
+	case "images":
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		method = r.Method
+	case "images/order", "images/cover", "images/meta":
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		method = sub
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if !requirePermission(w, r, permContentEdit) {
+		return
+	}
+	it, err := ct.getItem(id)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, ct.MaxFormSize)
+
+	var images []string
+	switch method {
+	case http.MethodPost:
+		images, err = ct.addImages(r, it.Images)
+	case http.MethodDelete:
+		images, err = removeImages(r, it.Images)
+	case "images/order":
+		images, err = reorderImages(r, it.Images)
+	case "images/cover":
+		images, err = coverImage(r, it.Images)
+	case "images/meta":
+		images, err = setImageMeta(r, it)
+	}
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	it.setImages(images)
+	if err := ct.updateImages(it); err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if err := ct.syncMediaRefs(db, it); err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	contentChanged(ct)
+	attachImageInfos(it)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(it)
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ct *ContentType) addImages(r *http.Request, current []string) ([]string, error) {
+	if !isMultipart(r) {
+		return nil, badRequest("Multipart form expected")
+	}
+	if err := parseUploadForm(r); err != nil {
+		return nil, err
+	}
+	added, err := checkFormUploads(r, "imgs", ct.MaxImages)
+	if err != nil {
+		return nil, err
+	}
+	cover, err := checkFormUploads(r, "img", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(added)+len(cover) == 0 {
+		return nil, badRequest("No files")
+	}
+	if len(current)+len(added)+len(cover) > ct.MaxImages {
+		return nil, &uploadError{Status: http.StatusBadRequest, Message: "Too many images", Field: "imgs", Limit: int64(ct.MaxImages)}
+	}
+	paths, err := saveUploads(append(cover, added...))
+	if err != nil {
+		log.Println("Upload error:", err)
+		return nil, &uploadError{Status: http.StatusInternalServerError, Message: "Upload error"}
+	}
+	// Повторно загруженный файл (тот же sha256) не дублируется в списке
+	images := append([]string(nil), current...)
+	for _, p := range paths[len(cover):] {
+		if indexOf(images, p) < 0 {
+			images = append(images, p)
+		}
+	}
+	if len(cover) > 0 {
+		if i := indexOf(images, paths[0]); i >= 0 {
+			images = append(images[:i], images[i+1:]...)
+		}
+		images = append([]string{paths[0]}, images...)
+	}
+	return images, nil
+}
+
+func removeImages(r *http.Request, current []string) ([]string, error) {
+	q := r.URL.Query()
+	if q.Get("all") == "1" || q.Get("all") == "true" {
+		return []string{}, nil
+	}
+	urls := q["url"]
+	if len(urls) == 0 {
+		return nil, badRequest("Missing url")
+	}
+	images := append([]string(nil), current...)
+	for _, u := range urls {
+		i := indexOf(images, u)
+		if i < 0 {
+			return nil, &uploadError{Status: http.StatusNotFound, Message: "Image not found", File: u}
+		}
+		images = append(images[:i], images[i+1:]...)
+	}
+	return images, nil
+}
+
+func reorderImages(r *http.Request, current []string) ([]string, error) {
+	var body struct {
+		Images []string `json:"images"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, badRequest("Invalid JSON")
+	}
+	// Только перестановка: тот же набор, без повторов
+	if len(body.Images) != len(current) {
+		return nil, badRequest("Order must list every current image exactly once")
+	}
+	seen := map[string]bool{}
+	for _, u := range body.Images {
+		if seen[u] || indexOf(current, u) < 0 {
+			return nil, badRequest("Order must list every current image exactly once")
+		}
+		seen[u] = true
+	}
+	return body.Images, nil
+}
+
+func coverImage(r *http.Request, current []string) ([]string, error) {
+	var body struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, badRequest("Invalid JSON")
+	}
+	i := indexOf(current, body.URL)
+	if i < 0 {
+		return nil, &uploadError{Status: http.StatusNotFound, Message: "Image not found", File: body.URL}
+	}
+	images := []string{current[i]}
+	images = append(images, current[:i]...)
+	return append(images, current[i+1:]...), nil
+}
+
+// setImageMeta заменяет подписи перечисленных картинок; остальные не трогает.
+func setImageMeta(r *http.Request, it *Item) ([]string, error) {
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, badRequest("Invalid JSON")
+	}
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '{' {
+		raw = append(append([]byte{'['}, raw...), ']')
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal(raw, &elems); err != nil || len(elems) == 0 {
+		return nil, badRequest("Invalid JSON")
+	}
+	for _, e := range elems {
+		// Строка вместо объекта ничего бы не задала
+		if len(e) == 0 || e[0] != '{' {
+			return nil, badRequest("Image meta must be an object with url")
+		}
+	}
+	urls, meta, err := parseImagesJSON(raw)
+	if err != nil {
+		return nil, imagesError(err, "Invalid JSON")
+	}
+	for _, u := range urls {
+		if indexOf(it.Images, u) < 0 {
+			return nil, &uploadError{Status: http.StatusNotFound, Message: "Image not found", File: u}
+		}
+		it.ImageMeta[u] = meta[u]
+	}
+	return it.Images, nil
+}
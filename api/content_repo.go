@@ -0,0 +1,334 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// --- CONTENT REPOSITORY ---
+// SQL строится из схемы ContentType; имена таблиц и колонок берутся только
+// из реестра, значения всегда идут через плейсхолдеры.
+
+// dataColumns — все колонки кроме id в порядке: img, images, поля схемы.
+func (ct *ContentType) dataColumns() []string {
+	cols := []string{"img", "images"}
+	for _, f := range ct.Fields {
+		cols = append(cols, f.Name)
+	}
+	return cols
+}
+
+// writableColumns — dataColumns без полей, которые заполняются отдельно (slug).
+func (ct *ContentType) writableColumns() []string {
+	cols := []string{"img", "images"}
+	for _, f := range ct.Fields {
+		if f.SlugOf == "" {
+			cols = append(cols, f.Name)
+		}
+	}
+	return cols
+}
+
+func (ct *ContentType) selectSQL() string {
+	exprs := []string{"id"}
+	for _, c := range ct.dataColumns() {
+		exprs = append(exprs, "IFNULL("+c+",'')")
+	}
+	exprs = append(exprs, "IFNULL(created_at,0)", "IFNULL(updated_at,0)")
+	return "SELECT " + strings.Join(exprs, ", ") + " FROM " + ct.Table
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (ct *ContentType) scanItem(row rowScanner) (*Item, error) {
+	it := ct.newItem()
+	var imagesJSON string
+	raw := make([]string, len(ct.Fields))
+	dest := []interface{}{&it.ID, &it.Img, &imagesJSON}
+	for i := range raw {
+		dest = append(dest, &raw[i])
+	}
+	dest = append(dest, &it.CreatedAt, &it.UpdatedAt)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if imagesJSON != "" {
+		it.Images, it.ImageMeta, _ = parseImagesJSON([]byte(imagesJSON))
+	}
+	for i, f := range ct.Fields {
+		if f.Kind == FieldList {
+			var arr []string
+			if raw[i] != "" {
+				_ = json.Unmarshal([]byte(raw[i]), &arr)
+			}
+			it.Values[f.Name] = arr
+		} else {
+			it.Values[f.Name] = raw[i]
+		}
+	}
+	return it, nil
+}
+
+// listItems возвращает все записи, новые первыми.
+func (ct *ContentType) listItems() ([]*Item, error) {
+	items, _, _, err := ct.queryItems(defaultListQuery())
+	return items, err
+}
+
+func (ct *ContentType) getItem(id string) (*Item, error) {
+	return ct.scanItem(db.QueryRow(ct.selectSQL()+" WHERE id = ?", id))
+}
+
+// values возвращает значения для writableColumns.
+func (it *Item) values() []interface{} {
+	args := []interface{}{it.Img, it.imagesJSON()}
+	for _, f := range it.ct.Fields {
+		if f.SlugOf != "" {
+			continue
+		}
+		if f.Kind == FieldList {
+			b, _ := json.Marshal(nonNil(it.List(f.Name)))
+			args = append(args, string(b))
+		} else {
+			args = append(args, it.Text(f.Name))
+		}
+	}
+	return args
+}
+
+func (ct *ContentType) insertItem(it *Item) error {
+	cols := ct.writableColumns()
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+	res, err := db.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", ct.Table, strings.Join(cols, ", "), marks), it.values()...)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	it.ID = int(id)
+	return nil
+}
+
+func (ct *ContentType) updateItem(id string, it *Item) error {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return err
+	}
+	it.ID = n
+	cols := ct.writableColumns()
+	sets := make([]string, len(cols))
+	for i, c := range cols {
+		sets[i] = c + "=?"
+	}
+	args := append(it.values(), id)
+	res, err := db.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE id=?", ct.Table, strings.Join(sets, ", ")), args...)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// updateImages меняет только img и images (content_images.go).
+func (ct *ContentType) updateImages(it *Item) error {
+	vals := it.values()
+	_, err := db.Exec(fmt.Sprintf("UPDATE %s SET img=?, images=? WHERE id=?", ct.Table), vals[0], vals[1], it.ID)
+	return err
+}
+
+func (ct *ContentType) deleteItem(id string) error {
+	_, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id=?", ct.Table), id)
+	return err
+}
+
+// --- LIST QUERY ---
+// Пагинация (limit/offset или cursor), сортировка и фильтры по полям схемы.
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type ListQuery struct {
+	Filters map[string]string // точное совпадение по текстовым полям
+	Sort    string            // "id" или текстовое поле схемы
+	Desc    bool
+	Limit   int // 0 — без ограничения
+	Offset  int
+	Cursor  *listCursor
+}
+
+// listCursor указывает на последнюю запись предыдущей страницы (keyset-пагинация).
+type listCursor struct {
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+func encodeCursor(c listCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func defaultListQuery() ListQuery {
+	return ListQuery{Sort: "id", Desc: true}
+}
+
+// parseListQuery разбирает ?limit=&offset=&cursor=&sort=-field&{field}=value.
+func (ct *ContentType) parseListQuery(q url.Values) (ListQuery, error) {
+	lq := defaultListQuery()
+	if s := q.Get("sort"); s != "" {
+		lq.Desc = strings.HasPrefix(s, "-")
+		name := strings.TrimPrefix(s, "-")
+		if f, ok := ct.field(name); name != "id" && (!ok || f.Kind != FieldText) {
+			return lq, badRequest("Unknown sort field: " + name)
+		}
+		lq.Sort = name
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return lq, badRequest("Invalid limit")
+		}
+		lq.Limit = n
+	}
+	if s := q.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return lq, badRequest("Invalid offset")
+		}
+		lq.Offset = n
+	}
+	if s := q.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return lq, badRequest("Invalid cursor")
+		}
+		lq.Cursor = c
+	}
+	if lq.Limit == 0 && (lq.Offset > 0 || lq.Cursor != nil) {
+		lq.Limit = defaultPageSize
+	}
+	if lq.Limit > maxPageSize {
+		lq.Limit = maxPageSize
+	}
+	for _, f := range ct.Fields {
+		if f.Kind == FieldText && q.Has(f.Name) {
+			if lq.Filters == nil {
+				lq.Filters = map[string]string{}
+			}
+			lq.Filters[f.Name] = q.Get(f.Name)
+		}
+	}
+	return lq, nil
+}
+
+func (lq ListQuery) sortExpr() string {
+	if lq.Sort == "" || lq.Sort == "id" {
+		return "id"
+	}
+	return "IFNULL(" + lq.Sort + ",'')"
+}
+
+// where строит условие по фильтрам; withCursor добавляет keyset-условие.
+func (ct *ContentType) where(lq ListQuery, withCursor bool) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	for _, f := range ct.Fields {
+		if v, ok := lq.Filters[f.Name]; ok {
+			conds = append(conds, "IFNULL("+f.Name+",'') = ?")
+			args = append(args, v)
+		}
+	}
+	if withCursor && lq.Cursor != nil {
+		op := ">"
+		if lq.Desc {
+			op = "<"
+		}
+		if lq.sortExpr() == "id" {
+			conds = append(conds, "id "+op+" ?")
+			args = append(args, lq.Cursor.ID)
+		} else {
+			e := lq.sortExpr()
+			conds = append(conds, fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", e, op, e, op))
+			args = append(args, lq.Cursor.Value, lq.Cursor.Value, lq.Cursor.ID)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// queryItems возвращает страницу, общее число записей под фильтром
+// и курсор следующей страницы (пустой, если страница последняя).
+func (ct *ContentType) queryItems(lq ListQuery) ([]*Item, int, string, error) {
+	countWhere, countArgs := ct.where(lq, false)
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM "+ct.Table+countWhere, countArgs...).Scan(&total); err != nil {
+		return nil, 0, "", err
+	}
+	where, args := ct.where(lq, true)
+	dir := "ASC"
+	if lq.Desc {
+		dir = "DESC"
+	}
+	query := ct.selectSQL() + where + " ORDER BY " + lq.sortExpr() + " " + dir
+	if lq.sortExpr() != "id" {
+		query += ", id " + dir
+	}
+	if lq.Limit > 0 {
+		// +1 чтобы понять, есть ли следующая страница
+		query += " LIMIT ?"
+		args = append(args, lq.Limit+1)
+		if lq.Cursor == nil && lq.Offset > 0 {
+			query += " OFFSET ?"
+			args = append(args, lq.Offset)
+		}
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer rows.Close()
+	items := []*Item{}
+	for rows.Next() {
+		if it, err := ct.scanItem(rows); err == nil {
+			items = append(items, it)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, "", err
+	}
+	next := ""
+	if lq.Limit > 0 && len(items) > lq.Limit {
+		items = items[:lq.Limit]
+		last := items[len(items)-1]
+		c := listCursor{ID: last.ID}
+		if lq.Sort != "id" && lq.Sort != "" {
+			c.Value = last.Text(lq.Sort)
+		}
+		next = encodeCursor(c)
+	}
+	return items, total, next, nil
+}
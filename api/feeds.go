@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- FEEDS ---
+// /feed.xml (RSS 2.0) и /atom.xml (Atom 1.0) по таблице blog, ?lang=ru|uz|en.
+// Картинки из images идут как enclosure: в RSS 2.0 допустим один, поэтому там
+// только обложка, в Atom — все (rel="enclosure"). Conditional GET: ETag по содержимому
+// и Last-Modified по последнему изменению блога.
+
+const feedSize = 50
+
+var feedTitles = map[string]string{
+	"ru": "Блог Influence Lab",
+	"uz": "Influence Lab Blogi",
+	"en": "Influence Lab Blog",
+}
+
+var feedDescriptions = map[string]string{
+	"ru": "Инфлюенсер-маркетинг в Узбекистане",
+	"uz": "O'zbekistonda influencer marketing",
+	"en": "Influencer marketing in Uzbekistan",
+}
+
+type feedEnclosure struct {
+	URL    string
+	Type   string
+	Length int64
+}
+
+type feedEntry struct {
+	ID, Title, Link, Summary string
+	Published, Updated       time.Time
+	Enclosures               []feedEnclosure
+}
+
+type feed struct {
+	Lang, Title, Description string
+	Link, SelfRSS, SelfAtom  string
+	Updated                  time.Time
+	Entries                  []feedEntry
+}
+
+// feedEnclosures описывает файлы картинок; размер берётся из media или с диска.
+func feedEnclosures(rootDir string, images []string) []feedEnclosure {
+	var out []feedEnclosure
+	for _, img := range images {
+		typ := mime.TypeByExtension(strings.ToLower(path.Ext(img)))
+		if typ == "" {
+			typ = "application/octet-stream"
+		}
+		enc := feedEnclosure{URL: assetURL(img), Type: typ}
+		if size, ok := mediaSize(img); ok {
+			enc.Length = size
+		} else if !strings.HasPrefix(img, "http") {
+			if st, err := os.Stat(filepath.Join(rootDir, filepath.FromSlash(strings.TrimPrefix(img, "/")))); err == nil {
+				enc.Length = st.Size()
+			}
+		}
+		out = append(out, enc)
+	}
+	return out
+}
+
+func buildFeed(rootDir, lang string) (*feed, error) {
+	ct := contentTypeByName("blog")
+	lq := defaultListQuery()
+	lq.Limit = feedSize
+	items, _, _, err := ct.queryItems(lq)
+	if err != nil {
+		return nil, err
+	}
+	q := ""
+	if lang != "ru" {
+		q = "?lang=" + lang
+	}
+	f := &feed{
+		Lang:        lang,
+		Title:       feedTitles[lang],
+		Description: feedDescriptions[lang],
+		Link:        absURL("/blog.html" + q),
+		SelfRSS:     absURL("/feed.xml" + q),
+		SelfAtom:    absURL("/atom.xml" + q),
+	}
+	for _, it := range items {
+		e := feedEntry{
+			// Адрес по id не меняется вместе со slug'ом
+			ID:         absURL(fmt.Sprintf("/%s?id=%d", ct.Page, it.ID)),
+			Title:      it.localText("title", lang),
+			Link:       absURL(ct.localizedURL(it, lang)),
+			Summary:    it.localText("description", lang),
+			Published:  time.Unix(it.CreatedAt, 0).UTC(),
+			Updated:    time.Unix(it.UpdatedAt, 0).UTC(),
+			Enclosures: feedEnclosures(rootDir, it.Images),
+		}
+		if e.Updated.After(f.Updated) {
+			f.Updated = e.Updated
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	if t := lastContentChange(); t.After(f.Updated) {
+		f.Updated = t.UTC().Truncate(time.Second)
+	}
+	return f, nil
+}
+
+// RSS 2.0
+
+type rssDoc struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Description string        `xml:"description"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate,omitempty"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+func (f *feed) rss() interface{} {
+	doc := rssDoc{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.Link,
+			Description: f.Description,
+			Language:    f.Lang,
+			Self:        atomLink{Href: f.SelfRSS, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if !f.Updated.IsZero() {
+		doc.Channel.LastBuildDate = f.Updated.Format(time.RFC1123Z)
+	}
+	for _, e := range f.Entries {
+		item := rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			Description: e.Summary,
+			GUID:        rssGUID{IsPermaLink: "false", Value: e.ID},
+		}
+		if e.Published.Unix() > 0 {
+			item.PubDate = e.Published.Format(time.RFC1123Z)
+		}
+		// Первая картинка — обложка
+		if len(e.Enclosures) > 0 {
+			enc := e.Enclosures[0]
+			item.Enclosure = &rssEnclosure{URL: enc.URL, Length: enc.Length, Type: enc.Type}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	return doc
+}
+
+// Atom 1.0
+
+type atomDoc struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Lang    string      `xml:"xml:lang,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published,omitempty"`
+	Summary   string     `xml:"summary"`
+	Links     []atomLink `xml:"link"`
+}
+
+func (f *feed) atom() interface{} {
+	doc := atomDoc{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		Lang:    f.Lang,
+		ID:      f.SelfAtom,
+		Title:   f.Title,
+		Updated: f.Updated.Format(time.RFC3339),
+		Author:  atomAuthor{Name: "Influence Lab"},
+		Links: []atomLink{
+			{Href: f.SelfAtom, Rel: "self", Type: "application/atom+xml"},
+			{Href: f.Link, Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for _, e := range f.Entries {
+		entry := atomEntry{
+			ID:      e.ID,
+			Title:   e.Title,
+			Updated: e.Updated.Format(time.RFC3339),
+			Summary: e.Summary,
+			Links:   []atomLink{{Href: e.Link, Rel: "alternate", Type: "text/html"}},
+		}
+		if e.Published.Unix() > 0 {
+			entry.Published = e.Published.Format(time.RFC3339)
+		}
+		for _, enc := range e.Enclosures {
+			entry.Links = append(entry.Links, atomLink{Href: enc.URL, Rel: "enclosure", Type: enc.Type, Length: enc.Length})
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	return doc
+}
+
+// handleFeed отдаёт ленту в формате format ("rss" или "atom").
+func handleFeed(rootDir, format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		lang := r.URL.Query().Get("lang")
+		if lang == "" {
+			lang = "ru"
+		}
+		if !validLang(lang) {
+			http.Error(w, "Lang must be 'ru', 'uz' or 'en'", http.StatusBadRequest)
+			return
+		}
+		f, err := buildFeed(rootDir, lang)
+		if err != nil {
+			log.Println("Feed error:", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		doc, contentType := f.rss(), "application/rss+xml; charset=utf-8"
+		if format == "atom" {
+			doc, contentType = f.atom(), "application/atom+xml; charset=utf-8"
+		}
+		var buf bytes.Buffer
+		buf.WriteString(xml.Header)
+		enc := xml.NewEncoder(&buf)
+		enc.Indent("", "  ")
+		if err := enc.Encode(doc); err != nil {
+			http.Error(w, "Feed error", http.StatusInternalServerError)
+			return
+		}
+		sum := sha256.Sum256(buf.Bytes())
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		// ServeContent сам отвечает 304 на If-None-Match / If-Modified-Since
+		http.ServeContent(w, r, "", f.Updated, bytes.NewReader(buf.Bytes()))
+	}
+}
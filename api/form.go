@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- FORM VALIDATION ---
+// Поля заявки чистятся от управляющих символов и проверяются до сохранения.
+// Ошибки возвращаются по полям, чтобы форма могла подсветить нужное:
+//
+//	400 {"error": "Invalid form", "fields": {"name": "required", "phone": "invalid"}}
+//
+// Коды: required, too_long, invalid. Телефон приводится к E.164: узбекские номера
+// понимаются в любом привычном виде (+998 90 123-45-67, 998901234567,
+// 8 90 1234567, 90 123 45 67), прочие — только с кодом страны (+ или 00).
+
+const (
+	maxLeadName        = 100
+	maxLeadDescription = 2000
+	maxLeadURL         = 1000
+	maxLeadUserAgent   = 500
+	maxFormBody        = 64 << 10 // тело запроса целиком: больше — 413 до разбора
+)
+
+// leadServices — услуги, которые посетитель может выбрать в форме; по ним
+// маршрутизируются уведомления (notify.go).
+var leadServices = []string{"influencers", "led", "it", "other"}
+
+type formErrors map[string]string
+
+// cleanText убирает управляющие символы и bidi-переопределения (ими подменяют
+// видимый текст). multiline оставляет переводы строк и табуляцию.
+func cleanText(s string, multiline bool) string {
+	s = strings.ToValidUTF8(s, "")
+	if multiline {
+		s = strings.ReplaceAll(s, "\r\n", "\n")
+	}
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case multiline && (r == '\n' || r == '\t'):
+			return r
+		case r == '\n' || r == '\t' || r == '\r':
+			return ' '
+		case unicode.IsControl(r), r >= 0x202A && r <= 0x202E, r >= 0x2066 && r <= 0x2069:
+			return -1
+		}
+		return r
+	}, s)
+	if !multiline {
+		s = strings.Join(strings.Fields(s), " ")
+	}
+	return strings.TrimSpace(s)
+}
+
+// truncateRunes обрезает строку до n символов.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// normalizePhone приводит номер к E.164; false — номер не распознан.
+func normalizePhone(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	plus := strings.HasPrefix(s, "+")
+	var digits strings.Builder
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ', r == '-', r == '(', r == ')', r == '.', r == '+' && i == 0:
+		default:
+			return "", false
+		}
+	}
+	d := digits.String()
+	if !plus && strings.HasPrefix(d, "00") {
+		d, plus = d[2:], true
+	}
+	switch {
+	case strings.HasPrefix(d, "998"):
+		// +998 и 12 цифр: код страны + 9 цифр номера
+		if len(d) != 12 {
+			return "", false
+		}
+	case plus:
+		// Другие страны: E.164 допускает до 15 цифр
+		if len(d) < 8 || len(d) > 15 || d[0] == '0' {
+			return "", false
+		}
+		return "+" + d, true
+	case len(d) == 9:
+		// Местный формат без кода: 90 123 45 67
+		d = "998" + d
+	case len(d) == 10 && d[0] == '8':
+		// Старый междугородний формат: 8 90 123 45 67
+		d = "998" + d[1:]
+	default:
+		return "", false
+	}
+	return "+" + d, true
+}
+
+// validateForm чистит и проверяет заявку; поля req приводятся к сохраняемому виду.
+func validateForm(req *FormRequest) formErrors {
+	errs := formErrors{}
+	req.Name = cleanText(req.Name, false)
+	switch {
+	case req.Name == "":
+		errs["name"] = "required"
+	case utf8.RuneCountInString(req.Name) > maxLeadName:
+		errs["name"] = "too_long"
+	}
+	switch phone, ok := normalizePhone(req.Phone); {
+	case strings.TrimSpace(req.Phone) == "":
+		errs["phone"] = "required"
+	case !ok:
+		errs["phone"] = "invalid"
+	default:
+		req.Phone = phone
+	}
+	req.Description = cleanText(req.Description, true)
+	if utf8.RuneCountInString(req.Description) > maxLeadDescription {
+		errs["description"] = "too_long"
+	}
+	req.Service = strings.TrimSpace(req.Service)
+	if req.Service != "" && indexOf(leadServices, req.Service) < 0 {
+		errs["service"] = "invalid"
+	}
+	// Служебные поля посетитель не видит — их просто обрезаем
+	req.Page = truncateRunes(cleanText(req.Page, false), maxLeadURL)
+	req.Referrer = truncateRunes(cleanText(req.Referrer, false), maxLeadURL)
+	return errs
+}
+
+func writeFormErrors(w http.ResponseWriter, errs formErrors) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "Invalid form", "fields": errs})
+}
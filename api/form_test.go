@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postForm(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleForm(w, httptest.NewRequest(http.MethodPost, "/api/form", strings.NewReader(body)))
+	return w
+}
+
+func TestHandleFormBodyLimit(t *testing.T) {
+	setupTestDB(t)
+	if err := initSpamProtection(); err != nil {
+		t.Fatal(err)
+	}
+	huge := `{"name":"Иван","phone":"+998901234567","description":"` + strings.Repeat("a", maxFormBody) + `"}`
+	if w := postForm(t, huge); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: status %d, want 413", w.Code)
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM leads").Scan(&n)
+	if n != 0 {
+		t.Errorf("%d lead(s) saved from an oversized body", n)
+	}
+
+	if w := postForm(t, `{"name":"Иван","phone":"+998901234567","description":"Нужна реклама","fill_ms":9000}`); w.Code != http.StatusOK {
+		t.Errorf("normal body: status %d: %s", w.Code, w.Body)
+	}
+	if w := postForm(t, `{"name":`); w.Code != http.StatusBadRequest {
+		t.Errorf("broken JSON: status %d, want 400", w.Code)
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		ok       bool
+	}{
+		{"+998901234567", "+998901234567", true},
+		{"+998 (90) 123-45-67", "+998901234567", true},
+		{"998901234567", "+998901234567", true},
+		{"00998901234567", "+998901234567", true},
+		{"90 123 45 67", "+998901234567", true},
+		{"8 90 123 45 67", "+998901234567", true},
+		{"  +998.90.123.45.67  ", "+998901234567", true},
+		// Другие страны — только с + или 00
+		{"+7 912 345-67-89", "+79123456789", true},
+		{"0044 20 7946 0958", "+442079460958", true},
+		{"+1234567", "", false},
+		{"+1234567890123456", "", false},
+		{"+0123456789", "", false},
+		// Узбекские номера строго из 12 цифр
+		{"+99890123456", "", false},
+		{"+9989012345678", "", false},
+		{"12345", "", false},
+		{"79123456789", "", false},
+		{"+998 90 123 45 67 доб. 1", "", false},
+		{"+998-90-123-45-67+", "", false},
+		{"++998901234567", "", false},
+		{"", "", false},
+	} {
+		got, ok := normalizePhone(tc.in)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("normalizePhone(%q) = %q, %v; want %q, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestCleanText(t *testing.T) {
+	for _, tc := range []struct {
+		name, in  string
+		multiline bool
+		want      string
+	}{
+		{"trim", "  Иван  ", false, "Иван"},
+		{"collapse spaces", "Иван \t\n Петров", false, "Иван Петров"},
+		{"control chars", "Ив\x00ан\x07\x1b[31m", false, "Иван[31m"},
+		{"bidi override", "abc\u202egnp.exe\u2066x\u2069", false, "abcgnp.exex"},
+		{"invalid utf-8", "Иван\xff\xfe", false, "Иван"},
+		{"multiline keeps lines", "строка 1\r\nстрока 2\n\tотступ", true, "строка 1\nстрока 2\n\tотступ"},
+		{"multiline strips controls", "a\rb\x00c\u202ed", true, "a bcd"},
+		{"multiline trims", "\n  текст  \n", true, "текст"},
+		{"empty", " \t\r\n ", false, ""},
+	} {
+		if got := cleanText(tc.in, tc.multiline); got != tc.want {
+			t.Errorf("%s: cleanText(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
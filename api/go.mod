@@ -3,6 +3,9 @@ module influencelab
 go 1.21
 
 require (
+	github.com/chai2010/webp v1.4.0
 	github.com/joho/godotenv v1.5.1
 	github.com/mattn/go-sqlite3 v1.14.22
+	golang.org/x/crypto v0.31.0
+	golang.org/x/image v0.24.0
 )
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// --- IMAGE META ---
+// В колонке images хранится JSON-массив объектов
+//
+//	[{"url": "...", "alt_ru": "...", "alt_uz": "...", "alt_en": "...",
+//	  "caption_ru": "...", "caption_uz": "...", "caption_en": "...",
+//	  "focal": {"x": 0.5, "y": 0.3}}]
+//
+// (пустые поля опускаются). В API "images" остаётся массивом URL, как раньше,
+// а подписи отдаются рядом в "image_details" в том же порядке. На вход
+// принимаются оба вида: строки и объекты, в том числе вперемешку. Объект
+// задаёт подписи целиком — пустой объект их очищает; строка оставляет прежние.
+
+const maxImageTextLen = 300
+
+// FocalPoint — точка, которую нужно сохранить при кадрировании; 0..1 от левого верхнего угла.
+type FocalPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type ImageMeta struct {
+	URL       string      `json:"url"`
+	AltRu     string      `json:"alt_ru,omitempty"`
+	AltUz     string      `json:"alt_uz,omitempty"`
+	AltEn     string      `json:"alt_en,omitempty"`
+	CaptionRu string      `json:"caption_ru,omitempty"`
+	CaptionUz string      `json:"caption_uz,omitempty"`
+	CaptionEn string      `json:"caption_en,omitempty"`
+	Focal     *FocalPoint `json:"focal,omitempty"`
+}
+
+// imageDetail — элемент image_details в API: все поля на месте, фокус по умолчанию — центр.
+type imageDetail struct {
+	URL       string     `json:"url"`
+	AltRu     string     `json:"alt_ru"`
+	AltUz     string     `json:"alt_uz"`
+	AltEn     string     `json:"alt_en"`
+	CaptionRu string     `json:"caption_ru"`
+	CaptionUz string     `json:"caption_uz"`
+	CaptionEn string     `json:"caption_en"`
+	Focal     FocalPoint `json:"focal"`
+}
+
+func (m *ImageMeta) validate() error {
+	for _, s := range []string{m.AltRu, m.AltUz, m.AltEn, m.CaptionRu, m.CaptionUz, m.CaptionEn} {
+		if len([]rune(s)) > maxImageTextLen {
+			return badRequest(fmt.Sprintf("Alt and caption must be at most %d characters", maxImageTextLen))
+		}
+	}
+	if f := m.Focal; f != nil && (f.X < 0 || f.X > 1 || f.Y < 0 || f.Y > 1) {
+		return badRequest("Focal point must be within 0..1")
+	}
+	return nil
+}
+
+// alt/caption на языке lang с откатом на русский.
+func (m *ImageMeta) alt(lang string) string {
+	switch {
+	case lang == "uz" && m.AltUz != "":
+		return m.AltUz
+	case lang == "en" && m.AltEn != "":
+		return m.AltEn
+	}
+	return m.AltRu
+}
+
+func (m *ImageMeta) caption(lang string) string {
+	switch {
+	case lang == "uz" && m.CaptionUz != "":
+		return m.CaptionUz
+	case lang == "en" && m.CaptionEn != "":
+		return m.CaptionEn
+	}
+	return m.CaptionRu
+}
+
+// parseImagesJSON разбирает images в обоих форматах: URL по порядку и
+// подписи по URL для каждого элемента-объекта, даже пустого.
+func parseImagesJSON(raw []byte) ([]string, map[string]*ImageMeta, error) {
+	var elems []json.RawMessage
+	if err := json.Unmarshal(raw, &elems); err != nil {
+		return nil, nil, err
+	}
+	var urls []string
+	meta := map[string]*ImageMeta{}
+	for _, e := range elems {
+		var s string
+		if err := json.Unmarshal(e, &s); err == nil {
+			urls = append(urls, s)
+			continue
+		}
+		m := &ImageMeta{}
+		if err := json.Unmarshal(e, m); err != nil {
+			return nil, nil, err
+		}
+		m.URL = strings.TrimSpace(m.URL)
+		if m.URL == "" {
+			return nil, nil, fmt.Errorf("image without url")
+		}
+		if err := m.validate(); err != nil {
+			return nil, nil, err
+		}
+		urls = append(urls, m.URL)
+		meta[m.URL] = m
+	}
+	return urls, meta, nil
+}
+
+// imagesError — ошибка разбора images для клиента: текст проверки подписи
+// (validate) как есть, остальное — msg.
+func imagesError(err error, msg string) error {
+	if b, ok := err.(badRequest); ok {
+		return b
+	}
+	return badRequest(msg)
+}
+
+// imagesJSON — значение колонки images.
+func (it *Item) imagesJSON() string {
+	elems := make([]*ImageMeta, 0, len(it.Images))
+	for _, u := range it.Images {
+		if m, ok := it.ImageMeta[u]; ok {
+			elems = append(elems, m)
+		} else {
+			elems = append(elems, &ImageMeta{URL: u})
+		}
+	}
+	b, _ := json.Marshal(elems)
+	return string(b)
+}
+
+// imageDetails — подписи всех картинок в порядке Images.
+func (it *Item) imageDetails() []imageDetail {
+	out := make([]imageDetail, 0, len(it.Images))
+	for _, u := range it.Images {
+		d := imageDetail{URL: u, Focal: FocalPoint{X: 0.5, Y: 0.5}}
+		if m, ok := it.ImageMeta[u]; ok {
+			d.AltRu, d.AltUz, d.AltEn = m.AltRu, m.AltUz, m.AltEn
+			d.CaptionRu, d.CaptionUz, d.CaptionEn = m.CaptionRu, m.CaptionUz, m.CaptionEn
+			if m.Focal != nil {
+				d.Focal = *m.Focal
+			}
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// imageAlt — alt картинки на языке lang; без подписи — заголовок записи.
+func (it *Item) imageAlt(url, lang string) string {
+	if m, ok := it.ImageMeta[url]; ok {
+		if a := m.alt(lang); a != "" {
+			return a
+		}
+	}
+	return it.localText("title", lang)
+}
+
+// imagePosition — CSS object-position по фокусу картинки.
+func (it *Item) imagePosition(url string) string {
+	f := FocalPoint{X: 0.5, Y: 0.5}
+	if m, ok := it.ImageMeta[url]; ok && m.Focal != nil {
+		f = *m.Focal
+	}
+	return fmt.Sprintf("%g%% %g%%", f.X*100, f.Y*100)
+}
+
+// keepImageMeta переносит подписи из сохранённой версии записи для картинок,
+// пришедших строкой (старые клиенты шлют images/imagesOld строками); пришедший
+// объект, даже пустой, заменяет подписи.
+func (it *Item) keepImageMeta(cur *Item) {
+	if cur == nil {
+		return
+	}
+	if it.ImageMeta == nil {
+		it.ImageMeta = map[string]*ImageMeta{}
+	}
+	for _, u := range it.Images {
+		if _, ok := it.ImageMeta[u]; ok {
+			continue
+		}
+		if m, ok := cur.ImageMeta[u]; ok {
+			it.ImageMeta[u] = m
+		}
+	}
+}
+
+// translateImageMeta применяет к записи подписи от переводчика: узбекские и
+// английские поля берутся из meta, русские и фокус должны совпадать с текущими,
+// иначе ok=false.
+func (it *Item) translateImageMeta(meta map[string]*ImageMeta) (changed, ok bool) {
+	for u, m := range meta {
+		cur, found := it.ImageMeta[u]
+		if !found {
+			cur = &ImageMeta{URL: u}
+		}
+		if m.AltRu != cur.AltRu || m.CaptionRu != cur.CaptionRu || !sameFocal(m.Focal, cur.Focal) {
+			return false, false
+		}
+		if m.AltUz == cur.AltUz && m.AltEn == cur.AltEn && m.CaptionUz == cur.CaptionUz && m.CaptionEn == cur.CaptionEn {
+			continue
+		}
+		n := *cur
+		n.AltUz, n.AltEn, n.CaptionUz, n.CaptionEn = m.AltUz, m.AltEn, m.CaptionUz, m.CaptionEn
+		it.ImageMeta[u] = &n
+		changed = true
+	}
+	return changed, true
+}
+
+func sameFocal(a, b *FocalPoint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// migrateImagesToObjects переводит колонки images из массива строк в массив объектов.
+func migrateImagesToObjects(tables ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, t := range tables {
+			if _, err := rewriteImagesColumn(tx, contentTypeByTable(t), func(it *Item) bool { return true }); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
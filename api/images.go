@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chai2010/webp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// --- IMAGES ---
+// Каждая загруженная картинка пережимается в несколько ширин (imageWidths)
+// в исходном семействе форматов (JPEG, либо PNG для картинок с прозрачностью)
+// и в WebP; EXIF-ориентация применяется при декодировании. Оригинал остаётся
+// в images как раньше, варианты кладутся в хранилище загрузок под variants/,
+// а их описание хранится в image_variants и отдаётся в API рядом с images
+// (поле image_variants) для srcset.
+//
+// Варианты строятся в фоне (startImageWorker): загрузка сохраняет оригинал и
+// сразу отвечает, пока вариантов нет — в API пустой variants. Очередь живёт в
+// памяти; что не успело обработаться до перезапуска, воркер подбирает при
+// старте тем же обходом, что и команда для уже загруженных картинок:
+//
+//	go run -tags sqlite_fts5 . images [-force]
+
+var imageWidths = []int{320, 640, 1024, 1600}
+
+const (
+	variantsPrefix = "variants/" // ключи вариантов в хранилище загрузок (storage.go)
+	jpegQuality    = 82
+	webpQuality    = 80
+)
+
+type ImageVariant struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Type   string `json:"type"`
+}
+
+// ImageInfo — исходная картинка и её варианты; Srcset — готовые строки
+// для <source type=...> / <img srcset>, по MIME-типу.
+type ImageInfo struct {
+	Src      string            `json:"src"`
+	Width    int               `json:"width,omitempty"`
+	Height   int               `json:"height,omitempty"`
+	Variants []ImageVariant    `json:"variants"`
+	Srcset   map[string]string `json:"srcset"`
+}
+
+func (info *ImageInfo) buildSrcset() {
+	info.Srcset = map[string]string{}
+	for _, v := range info.Variants {
+		entry := fmt.Sprintf("%s %dw", srcsetURL(v.URL), v.Width)
+		if s := info.Srcset[v.Type]; s != "" {
+			info.Srcset[v.Type] = s + ", " + entry
+		} else {
+			info.Srcset[v.Type] = entry
+		}
+	}
+}
+
+// srcsetURL экранирует путь для srcset: пробел и запятая там — разделители.
+func srcsetURL(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// publicToFS переводит публичный путь /img/... в путь на диске.
+func publicToFS(p string) string {
+	return filepath.Join("..", filepath.FromSlash(strings.TrimPrefix(p, "/")))
+}
+
+// readImageSource читает картинку из хранилища загрузок, а прочие локальные
+// пути (/img/...) — с диска.
+func readImageSource(src string) ([]byte, error) {
+	if key, ok := storageKey(src); ok {
+		return readStored(key)
+	}
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return nil, fmt.Errorf("external image")
+	}
+	return os.ReadFile(publicToFS(src))
+}
+
+// decodeImage декодирует картинку и поворачивает её по EXIF (только JPEG).
+func decodeImage(data []byte) (image.Image, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if format == "jpeg" {
+		img = applyOrientation(img, exifOrientation(data))
+	}
+	return img, format, nil
+}
+
+// exifOrientation читает тег Orientation (0x0112) из APP1 JPEG; 1 — по умолчанию.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || size < 2 || i+2+size > len(data) {
+			return 1
+		}
+		seg := data[i+4 : i+2+size]
+		if marker == 0xE1 && len(seg) > 14 && string(seg[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(seg[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+func tiffOrientation(t []byte) int {
+	var order binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	off := int(order.Uint32(t[4:]))
+	if off+2 > len(t) {
+		return 1
+	}
+	n := int(order.Uint16(t[off:]))
+	for k := 0; k < n; k++ {
+		e := off + 2 + k*12
+		if e+12 > len(t) {
+			return 1
+		}
+		if order.Uint16(t[e:]) == 0x0112 {
+			if v := int(order.Uint16(t[e+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation приводит картинку к ориентации 1.
+func applyOrientation(src image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var nx, ny int
+			switch o {
+			case 2:
+				nx, ny = w-1-x, y
+			case 3:
+				nx, ny = w-1-x, h-1-y
+			case 4:
+				nx, ny = x, h-1-y
+			case 5:
+				nx, ny = y, x
+			case 6:
+				nx, ny = h-1-y, x
+			case 7:
+				nx, ny = h-1-y, w-1-x
+			case 8:
+				nx, ny = y, w-1-x
+			}
+			dst.Set(nx, ny, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+func hasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	return true
+}
+
+func resizeImage(src image.Image, width int) *image.NRGBA {
+	b := src.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
+
+func encodeVariant(w io.Writer, img image.Image, typ string) error {
+	switch typ {
+	case "image/webp":
+		return webp.Encode(w, img, &webp.Options{Quality: webpQuality})
+	case "image/png":
+		return png.Encode(w, img)
+	default:
+		// JPEG без альфы: подкладываем белый фон
+		bg := image.NewRGBA(img.Bounds())
+		draw.Draw(bg, bg.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(bg, bg.Bounds(), img, img.Bounds().Min, draw.Over)
+		return jpeg.Encode(w, bg, &jpeg.Options{Quality: jpegQuality})
+	}
+}
+
+var variantExts = map[string]string{"image/webp": ".webp", "image/png": ".png", "image/jpeg": ".jpg"}
+
+// processImage строит варианты для картинки по публичному пути src и
+// сохраняет их описание в image_variants.
+func processImage(src string) (*ImageInfo, error) {
+	data, err := readImageSource(src)
+	if err != nil {
+		return nil, err
+	}
+	img, format, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	info := &ImageInfo{Src: src, Width: b.Dx(), Height: b.Dy()}
+
+	fallback := "image/jpeg"
+	if format == "png" || format == "gif" || format == "webp" {
+		if hasAlpha(img) {
+			fallback = "image/png"
+		}
+	}
+	// Ширины меньше исходной + сама исходная, если она меньше максимальной
+	var widths []int
+	for _, w := range imageWidths {
+		if w < b.Dx() {
+			widths = append(widths, w)
+		}
+	}
+	if b.Dx() <= imageWidths[len(imageWidths)-1] {
+		widths = append(widths, b.Dx())
+	}
+
+	base := variantBase(src)
+	for _, w := range widths {
+		resized := img
+		if w != b.Dx() {
+			resized = resizeImage(img, w)
+		}
+		for _, typ := range []string{"image/webp", fallback} {
+			var buf bytes.Buffer
+			if err := encodeVariant(&buf, resized, typ); err != nil {
+				return nil, fmt.Errorf("%s %dw: %w", typ, w, err)
+			}
+			key := fmt.Sprintf("%s%s-%d%s", variantsPrefix, base, w, variantExts[typ])
+			if err := store.Put(key, &buf, int64(buf.Len()), typ); err != nil {
+				return nil, err
+			}
+			rb := resized.Bounds()
+			info.Variants = append(info.Variants, ImageVariant{
+				URL: storageURL(key), Width: rb.Dx(), Height: rb.Dy(), Type: typ,
+			})
+		}
+	}
+	info.buildSrcset()
+	variantsJSON, _ := json.Marshal(info.Variants)
+	_, err = db.Exec(`INSERT INTO image_variants (src, width, height, variants, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(src) DO UPDATE SET width = excluded.width, height = excluded.height, variants = excluded.variants`,
+		src, info.Width, info.Height, string(variantsJSON), time.Now().Unix())
+	return info, err
+}
+
+// variantBase — общая часть ключей вариантов картинки src: имя файла без
+// расширения и хэш полного пути, чтобы a.jpg и a.png, как и одинаковые имена
+// в разных папках, не делили варианты.
+func variantBase(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	return strings.TrimSuffix(path.Base(src), path.Ext(src)) + "-" + hex.EncodeToString(sum[:4])
+}
+
+// processUploads строит варианты сразу (для команд); ошибка обработки не
+// мешает сохранить запись — картинка просто останется без вариантов.
+func processUploads(paths []string) {
+	for _, p := range paths {
+		if _, err := processImage(p); err != nil {
+			log.Println("Image processing error:", p, err)
+		}
+	}
+}
+
+var imageQueue = make(chan string, 1000)
+
+// queueImages ставит новые загрузки в очередь воркера. Запрос не ждёт и при
+// переполненной очереди — такие картинки подберёт обход при следующем старте.
+func queueImages(paths []string) {
+	for _, p := range paths {
+		select {
+		case imageQueue <- p:
+		default:
+			log.Println("Image queue is full, variants will be built on next start:", p)
+		}
+	}
+}
+
+// startImageWorker запускает фоновую обработку: сначала картинки без
+// вариантов, оставшиеся с прошлого запуска, затем очередь новых загрузок.
+func startImageWorker() {
+	go func() {
+		if done, failed, err := processMissingImages(false); err != nil {
+			log.Println("[images] backfill error:", err)
+		} else if done+failed > 0 {
+			log.Printf("[images] backfill: processed %d, failed %d", done, failed)
+		}
+		for p := range imageQueue {
+			if _, err := processImage(p); err != nil {
+				log.Println("Image processing error:", p, err)
+			}
+		}
+	}()
+}
+
+// loadImageInfos возвращает описания вариантов для списка картинок.
+func loadImageInfos(srcs []string) (map[string]*ImageInfo, error) {
+	out := map[string]*ImageInfo{}
+	if len(srcs) == 0 {
+		return out, nil
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(srcs)), ", ")
+	args := make([]interface{}, len(srcs))
+	for i, s := range srcs {
+		args[i] = s
+	}
+	rows, err := db.Query("SELECT src, width, height, variants FROM image_variants WHERE src IN ("+marks+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		info := &ImageInfo{}
+		var variantsJSON string
+		if err := rows.Scan(&info.Src, &info.Width, &info.Height, &variantsJSON); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal([]byte(variantsJSON), &info.Variants)
+		info.buildSrcset()
+		out[info.Src] = info
+	}
+	return out, rows.Err()
+}
+
+// attachImageInfos заполняет it.ImageInfos для выдачи в API.
+func attachImageInfos(items ...*Item) {
+	var srcs []string
+	for _, it := range items {
+		srcs = append(srcs, it.Images...)
+	}
+	infos, err := loadImageInfos(srcs)
+	if err != nil {
+		log.Println("Image variants error:", err)
+		return
+	}
+	for _, it := range items {
+		it.ImageInfos = make([]*ImageInfo, len(it.Images))
+		for i, src := range it.Images {
+			if info, ok := infos[src]; ok {
+				it.ImageInfos[i] = info
+			} else {
+				it.ImageInfos[i] = &ImageInfo{Src: src, Variants: []ImageVariant{}, Srcset: map[string]string{}}
+			}
+		}
+	}
+}
+
+// runImagesCommand строит варианты для всех картинок из таблиц контента.
+func runImagesCommand(args []string) error {
+	fs := flag.NewFlagSet("images", flag.ContinueOnError)
+	force := fs.Bool("force", false, "пересобрать и уже обработанные картинки")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	done, failed, err := processMissingImages(*force)
+	if err != nil {
+		return err
+	}
+	log.Printf("[images] processed %d, failed %d", done, failed)
+	return nil
+}
+
+// processMissingImages строит варианты для картинок из таблиц контента, у
+// которых их ещё нет (force — для всех).
+func processMissingImages(force bool) (done, failed int, err error) {
+	seen := map[string]bool{}
+	for _, ct := range contentTypes {
+		items, err := ct.listItems()
+		if err != nil {
+			return done, failed, err
+		}
+		for _, it := range items {
+			for _, src := range it.Images {
+				if _, ok := storageKey(src); seen[src] || !ok && strings.HasPrefix(src, "http") {
+					continue
+				}
+				seen[src] = true
+				if !force {
+					var one int
+					if err := db.QueryRow("SELECT 1 FROM image_variants WHERE src = ?", src).Scan(&one); err == nil {
+						continue
+					} else if err != sql.ErrNoRows {
+						return done, failed, err
+					}
+				}
+				if _, err := processImage(src); err != nil {
+					log.Println("[images]", src, err)
+					failed++
+					continue
+				}
+				done++
+			}
+		}
+	}
+	return done, failed, nil
+}
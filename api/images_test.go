@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestProcessImageVariantKeys(t *testing.T) {
+	setupTestDB(t)
+	prev := store
+	store = &localStorage{dir: t.TempDir()}
+	t.Cleanup(func() { store = prev })
+
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.Set(0, 0, color.RGBA{A: 0x80}) // прозрачность: PNG-вариант остаётся PNG
+	var jpg, pngData bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatal(err)
+	}
+	// Одно имя с разными расширениями и в разных папках
+	sources := map[string][]byte{"a.jpg": jpg.Bytes(), "a.png": pngData.Bytes(), "2024/a.jpg": jpg.Bytes()}
+	owner := map[string]string{}
+	for key, data := range sources {
+		if err := store.Put(key, bytes.NewReader(data), int64(len(data)), ""); err != nil {
+			t.Fatal(err)
+		}
+		info, err := processImage(storageURL(key))
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		if len(info.Variants) == 0 {
+			t.Fatalf("%s: no variants", key)
+		}
+		for _, v := range info.Variants {
+			if other, ok := owner[v.URL]; ok {
+				t.Errorf("%s and %s share variant %s", other, key, v.URL)
+			}
+			owner[v.URL] = key
+		}
+	}
+
+	// Варианты a.png по-прежнему из a.png: PNG с прозрачностью, а не JPEG от a.jpg
+	infos, err := loadImageInfos([]string{storageURL("a.png")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range infos[storageURL("a.png")].Variants {
+		if v.Type == "image/jpeg" {
+			t.Errorf("a.png got a JPEG variant %s", v.URL)
+		}
+	}
+}
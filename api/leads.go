@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- LEADS ---
+// Каждая заявка с /api/form сначала сохраняется в таблицу leads, и только
+// потом уходит в каналы уведомлений через очередь (outbox.go, notify.go):
+// посетитель получает ok, даже если каналы недоступны или не настроены, а
+// заявка остаётся в админке.
+//
+//	GET /api/leads?q=&from=2024-01-31&to=2024-02-29&status=new&assignee=me&delivery=failed&service=led&spam=0&limit=50&offset=0
+//	GET /api/leads?format=csv&...   те же фильтры, выгрузка в CSV (без limit — все)
+//
+// status — этап работы с заявкой (leads_pipeline.go): new, contacted, qualified,
+// won, lost. assignee — id менеджера, me или none. delivery — доставка
+// уведомлений: pending (в очереди), sent, failed (попытки исчерпаны), skipped
+// (нет подходящих каналов или спам); для старых клиентов эти значения
+// принимаются и в status. spam: 0 (по умолчанию) — без спама, 1 — только спам,
+// all — все.
+
+// Доставка уведомлений о заявке (telegram_status)
+const (
+	leadPending = "pending"
+	leadSent    = "sent"
+	leadFailed  = "failed"
+	leadSkipped = "skipped"
+)
+
+var leadDeliveryStatuses = []string{leadPending, leadSent, leadFailed, leadSkipped}
+
+type Lead struct {
+	ID             int64  `json:"id"`
+	Name           string `json:"name"`
+	Phone          string `json:"phone"`
+	Description    string `json:"description"`
+	Service        string `json:"service,omitempty"` // leadServices (form.go)
+	IP             string `json:"ip"`
+	UserAgent      string `json:"user_agent"`
+	Referrer       string `json:"referrer"`
+	Page           string `json:"page"`
+	Status         string `json:"status"`                // этап: leadStatuses
+	AssigneeID     int64  `json:"assignee_id,omitempty"` // users.id менеджера
+	Assignee       string `json:"assignee,omitempty"`    // его логин
+	TelegramStatus string `json:"telegram_status"`
+	TelegramError  string `json:"telegram_error,omitempty"`
+	Spam           bool   `json:"spam"`
+	SpamReason     string `json:"spam_reason,omitempty"` // honeypot, too_fast, captcha (spam.go), manual
+	CreatedAt      int64  `json:"created_at"`
+}
+
+// clientIP — адрес посетителя. За прокси (TRUST_PROXY=1) берётся первый
+// адрес из X-Forwarded-For / X-Real-IP, иначе им можно подменить IP.
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") == "1" {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			first, _, _ := strings.Cut(xff, ",")
+			return strings.TrimSpace(first)
+		}
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return strings.TrimSpace(ip)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// --- FORM HANDLER ---
+func handleForm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBody)
+	var req FormRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if errs := validateForm(&req); len(errs) > 0 {
+		writeFormErrors(w, errs)
+		return
+	}
+	ip := clientIP(r)
+	if !checkFormRate(w, ip, req.Phone) {
+		return
+	}
+	lead := &Lead{
+		Name:        req.Name,
+		Phone:       req.Phone,
+		Description: req.Description,
+		Service:     req.Service,
+		IP:          ip,
+		UserAgent:   truncateRunes(cleanText(r.UserAgent(), false), maxLeadUserAgent),
+		Referrer:    req.Referrer,
+		Page:        req.Page,
+		SpamReason:  spamReason(&req, ip),
+		CreatedAt:   time.Now().Unix(),
+	}
+	lead.Spam = lead.SpamReason != ""
+	// Страница формы — из тела запроса, для старых клиентов — из Referer
+	if lead.Page == "" {
+		lead.Page = truncateRunes(cleanText(r.Referer(), false), maxLeadURL)
+	}
+	if err := saveLead(lead); err != nil {
+		log.Println("[form] DB error:", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	// Заявка сохранена — уведомление доставит воркер (outbox.go)
+	wakeOutbox()
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// saveLead сохраняет заявку и ставит уведомление в очередь одной транзакцией.
+// Спам и заявки, для которых не нашлось каналов, только сохраняются (skipped).
+func saveLead(l *Lead) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	l.Status = leadNew
+	l.TelegramStatus = leadPending
+	var channels []*notifyChannel
+	if l.Spam {
+		log.Printf("[form] suspected spam (%s) from %s saved only. Name: %s Phone: %s", l.SpamReason, l.IP, l.Name, l.Phone)
+		l.TelegramStatus = leadSkipped
+	} else if channels = routeLead(l); len(channels) == 0 {
+		log.Printf("[form] no notification channels, lead saved only. Name: %s Phone: %s", l.Name, l.Phone)
+		l.TelegramStatus = leadSkipped
+	}
+	if err := insertLead(tx, l); err != nil {
+		return err
+	}
+	if l.TelegramStatus == leadPending {
+		if err := enqueueLeadNotifications(tx, l, channels); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func insertLead(q queryExecer, l *Lead) error {
+	res, err := q.Exec(`INSERT INTO leads (name, phone, description, service, ip, user_agent, referrer, page, status, telegram_status, spam, spam_reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		l.Name, l.Phone, l.Description, l.Service, l.IP, l.UserAgent, l.Referrer, l.Page, l.Status, l.TelegramStatus, l.Spam, l.SpamReason, l.CreatedAt)
+	if err != nil {
+		return err
+	}
+	l.ID, err = res.LastInsertId()
+	return err
+}
+
+// leadMessage — текст уведомления о заявке.
+func leadMessage(l *Lead) string {
+	msg := fmt.Sprintf("Новая заявка!\nИмя: %s\nТелефон: %s", l.Name, l.Phone)
+	if l.Service != "" {
+		msg += "\nУслуга: " + l.Service
+	}
+	return msg + "\nОписание: " + l.Description
+}
+
+// --- LEADS API ---
+
+type leadFilter struct {
+	Query      string
+	From, To   int64 // unix-время, To не включительно; 0 — без границы
+	Status     string
+	Delivery   string
+	Service    string
+	Assignee   int64 // 0 — любой
+	Unassigned bool
+	Spam       string // "0", "1" или "all"
+	Limit      int    // 0 — без ограничения
+	Offset     int
+}
+
+// parseLeadFilter читает фильтры из query. Даты — YYYY-MM-DD (to включительно)
+// или unix-время.
+func parseLeadFilter(r *http.Request, defaultLimit int) (leadFilter, error) {
+	q := r.URL.Query()
+	f := leadFilter{Query: strings.TrimSpace(q.Get("q")), Status: q.Get("status"), Delivery: q.Get("delivery"),
+		Service: q.Get("service"), Spam: q.Get("spam"), Limit: defaultLimit}
+	if f.Delivery == "" && indexOf(leadDeliveryStatuses, f.Status) >= 0 {
+		// До появления этапов status означал доставку
+		f.Status, f.Delivery = "", f.Status
+	}
+	if f.Status != "" && indexOf(leadStatuses, f.Status) < 0 {
+		return f, badRequest("Invalid status")
+	}
+	if f.Delivery != "" && indexOf(leadDeliveryStatuses, f.Delivery) < 0 {
+		return f, badRequest("Invalid delivery")
+	}
+	switch v := q.Get("assignee"); v {
+	case "":
+	case "none":
+		f.Unassigned = true
+	case "me":
+		if u := currentUser(r); u != nil {
+			f.Assignee = int64(u.ID)
+		}
+	default:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return f, badRequest("Invalid assignee")
+		}
+		f.Assignee = n
+	}
+	if f.Service != "" && indexOf(leadServices, f.Service) < 0 {
+		return f, badRequest("Invalid service")
+	}
+	switch f.Spam {
+	case "":
+		f.Spam = "0"
+	case "0", "1", "all":
+	default:
+		return f, badRequest("Invalid spam")
+	}
+	parseDate := func(name string, endOfDay bool) (int64, error) {
+		v := q.Get(name)
+		if v == "" {
+			return 0, nil
+		}
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n, nil
+		}
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return 0, badRequest("Invalid " + name)
+		}
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t.Unix(), nil
+	}
+	var err error
+	if f.From, err = parseDate("from", false); err != nil {
+		return f, err
+	}
+	if f.To, err = parseDate("to", true); err != nil {
+		return f, err
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			return f, badRequest("Invalid limit")
+		}
+		f.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return f, badRequest("Invalid offset")
+		}
+		f.Offset = n
+	}
+	return f, nil
+}
+
+// where — условие SQL по фильтрам (кроме limit/offset); колонки — таблицы leads.
+func (f leadFilter) where() (string, []interface{}) {
+	where := "1 = 1"
+	var args []interface{}
+	if f.Query != "" {
+		like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.Query) + "%"
+		where += ` AND (leads.name LIKE ? ESCAPE '\' OR leads.phone LIKE ? ESCAPE '\' OR leads.description LIKE ? ESCAPE '\')`
+		args = append(args, like, like, like)
+	}
+	if f.From > 0 {
+		where += " AND leads.created_at >= ?"
+		args = append(args, f.From)
+	}
+	if f.To > 0 {
+		where += " AND leads.created_at < ?"
+		args = append(args, f.To)
+	}
+	if f.Status != "" {
+		where += " AND leads.status = ?"
+		args = append(args, f.Status)
+	}
+	if f.Delivery != "" {
+		where += " AND leads.telegram_status = ?"
+		args = append(args, f.Delivery)
+	}
+	if f.Service != "" {
+		where += " AND leads.service = ?"
+		args = append(args, f.Service)
+	}
+	if f.Assignee != 0 {
+		where += " AND leads.assignee_id = ?"
+		args = append(args, f.Assignee)
+	} else if f.Unassigned {
+		where += " AND leads.assignee_id IS NULL"
+	}
+	if f.Spam != "all" {
+		where += " AND leads.spam = ?"
+		args = append(args, f.Spam == "1")
+	}
+	return where, args
+}
+
+const leadSelect = `SELECT leads.id, leads.name, leads.phone, leads.description, leads.service, leads.ip, leads.user_agent,
+	leads.referrer, leads.page, leads.status, IFNULL(leads.assignee_id, 0), IFNULL(users.login, ''),
+	leads.telegram_status, leads.telegram_error, leads.spam, leads.spam_reason, leads.created_at
+	FROM leads LEFT JOIN users ON users.id = leads.assignee_id`
+
+func scanLead(row rowScanner) (*Lead, error) {
+	var l Lead
+	err := row.Scan(&l.ID, &l.Name, &l.Phone, &l.Description, &l.Service, &l.IP, &l.UserAgent, &l.Referrer, &l.Page,
+		&l.Status, &l.AssigneeID, &l.Assignee, &l.TelegramStatus, &l.TelegramError, &l.Spam, &l.SpamReason, &l.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func getLead(id int64) (*Lead, error) {
+	return scanLead(db.QueryRow(leadSelect+" WHERE leads.id = ?", id))
+}
+
+func queryLeads(f leadFilter) ([]Lead, int, error) {
+	where, args := f.where()
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM leads WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	limit := f.Limit
+	if limit == 0 {
+		limit = -1 // в SQLite — без ограничения
+	}
+	rows, err := db.Query(leadSelect+` WHERE `+where+` ORDER BY leads.created_at DESC, leads.id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	list := []Lead{}
+	for rows.Next() {
+		l, err := scanLead(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, *l)
+	}
+	return list, total, rows.Err()
+}
+
+// GET /api/leads
+func handleLeads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	csvExport := r.URL.Query().Get("format") == "csv"
+	defaultLimit := 50
+	if csvExport {
+		defaultLimit = 0
+	}
+	f, err := parseLeadFilter(r, defaultLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	list, total, err := queryLeads(f)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if csvExport {
+		writeLeadsCSV(w, list)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": list, "total": total, "limit": f.Limit, "offset": f.Offset})
+}
+
+func writeLeadsCSV(w http.ResponseWriter, list []Lead) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="leads-%s.csv"`, time.Now().Format("2006-01-02")))
+	// BOM — чтобы Excel открыл кириллицу в UTF-8
+	w.Write([]byte("\ufeff"))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "created_at", "name", "phone", "description", "service", "ip", "user_agent", "referrer", "page", "status", "assignee", "telegram_status", "spam_reason"})
+	for _, l := range list {
+		cw.Write([]string{
+			strconv.FormatInt(l.ID, 10),
+			time.Unix(l.CreatedAt, 0).Format("2006-01-02 15:04:05"),
+			csvSafe(l.Name), csvSafe(l.Phone), csvSafe(l.Description), l.Service,
+			l.IP, csvSafe(l.UserAgent), csvSafe(l.Referrer), csvSafe(l.Page), l.Status, csvSafe(l.Assignee), l.TelegramStatus, l.SpamReason,
+		})
+	}
+	cw.Flush()
+}
+
+// csvSafe не даёт табличным редакторам выполнить формулу из текста посетителя.
+// Телефон вида +998 90 123-45-67 формулой не считается и остаётся как есть.
+func csvSafe(s string) string {
+	if s == "" {
+		return s
+	}
+	switch s[0] {
+	case '=', '@', '\t', '\r':
+		return "'" + s
+	case '+', '-':
+		if strings.Trim(s[1:], "0123456789 ()-") != "" {
+			return "'" + s
+		}
+	}
+	return s
+}
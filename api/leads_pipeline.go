@@ -0,0 +1,410 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// --- LEAD PIPELINE ---
+// Работа менеджеров с заявкой: этап (status), ответственный и заметки. Каждое
+// изменение пишется в lead_events — из них собирается история заявки.
+//
+//	GET   /api/leads/{id}         заявка и история (timeline)
+//	PATCH /api/leads/{id}         {"status": "contacted", "assignee_id": 3, "spam": false, "note": "перезвонить"}
+//	                              assignee_id: 0 — снять ответственного; note — комментарий к изменению
+//	POST  /api/leads/{id}/notes   {"text": "..."}
+//	GET   /api/leads/stats?from=&to=&group=month&service=&assignee=
+//
+// Статистика считается по заявкам, созданным в периоде (когорта): сколько из
+// них сейчас на каждом этапе, conversion = won / всего, win_rate = won / (won + lost).
+
+const (
+	leadNew       = "new"
+	leadContacted = "contacted"
+	leadQualified = "qualified"
+	leadWon       = "won"
+	leadLost      = "lost"
+)
+
+var leadStatuses = []string{leadNew, leadContacted, leadQualified, leadWon, leadLost}
+
+// Типы событий в истории заявки
+const (
+	leadEventStatus = "status"
+	leadEventAssign = "assign"
+	leadEventNote   = "note"
+	leadEventSpam   = "spam"
+)
+
+const maxLeadNote = 2000
+
+type LeadEvent struct {
+	ID        int64  `json:"id"`
+	Type      string `json:"type"`
+	From      string `json:"from,omitempty"` // status: этап, assign: логин
+	To        string `json:"to,omitempty"`
+	Note      string `json:"note,omitempty"`
+	UserID    int64  `json:"user_id,omitempty"`
+	User      string `json:"user,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// leadChange — правка заявки; nil-поля не меняются. Take — назначить
+// AssigneeID, только если заявка ещё ничья (или уже его): иначе errLeadTaken.
+type leadChange struct {
+	Status     *string `json:"status"`
+	AssigneeID *int64  `json:"assignee_id"`
+	Spam       *bool   `json:"spam"`
+	Note       string  `json:"note"`
+	Take       bool    `json:"-"`
+}
+
+var errLeadTaken = errors.New("lead is already taken")
+
+func addLeadEvent(q queryExecer, leadID int64, userID int, typ, from, to, note string) error {
+	var uid interface{}
+	if userID != 0 {
+		uid = userID
+	}
+	_, err := q.Exec(`INSERT INTO lead_events (lead_id, user_id, type, old_value, new_value, note, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, leadID, uid, typ, from, to, note, time.Now().Unix())
+	return err
+}
+
+// cleanLeadNote чистит текст заметки; badRequest, если он слишком длинный.
+func cleanLeadNote(s string) (string, error) {
+	s = cleanText(s, true)
+	if utf8.RuneCountInString(s) > maxLeadNote {
+		return "", badRequest("Note is too long")
+	}
+	return s, nil
+}
+
+// updateLead применяет правку и пишет события в историю. Возвращает
+// sql.ErrNoRows, если заявки нет, badRequest для неверных значений и
+// errLeadTaken, если при Take заявку уже взял другой.
+func updateLead(id int64, by *User, ch leadChange) error {
+	note, err := cleanLeadNote(ch.Note)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var status, assignee string
+	var assigneeID int64
+	var spam bool
+	err = tx.QueryRow(`SELECT leads.status, IFNULL(leads.assignee_id, 0), IFNULL(users.login, ''), leads.spam
+		FROM leads LEFT JOIN users ON users.id = leads.assignee_id WHERE leads.id = ?`, id).Scan(&status, &assigneeID, &assignee, &spam)
+	if err != nil {
+		return err
+	}
+	userID := 0
+	if by != nil {
+		userID = by.ID
+	}
+	if ch.Status != nil && *ch.Status != status {
+		if indexOf(leadStatuses, *ch.Status) < 0 {
+			return badRequest("Invalid status")
+		}
+		if _, err := tx.Exec("UPDATE leads SET status = ? WHERE id = ?", *ch.Status, id); err != nil {
+			return err
+		}
+		if err := addLeadEvent(tx, id, userID, leadEventStatus, status, *ch.Status, note); err != nil {
+			return err
+		}
+		note = "" // комментарий — к первому событию правки
+	}
+	if ch.AssigneeID != nil && *ch.AssigneeID != assigneeID {
+		var newID interface{}
+		login := ""
+		if *ch.AssigneeID != 0 {
+			var u User
+			err := tx.QueryRow("SELECT id, login, role FROM users WHERE id = ?", *ch.AssigneeID).Scan(&u.ID, &u.Login, &u.Role)
+			if errors.Is(err, sql.ErrNoRows) || (err == nil && !hasPermission(&u, permLeadsManage)) {
+				return badRequest("Invalid assignee")
+			}
+			if err != nil {
+				return err
+			}
+			newID, login = u.ID, u.Login
+		}
+		q, args := "UPDATE leads SET assignee_id = ? WHERE id = ?", []interface{}{newID, id}
+		if ch.Take {
+			// Проверка и запись одним запросом: из двух одновременных «взять» пройдёт одно
+			q += " AND (assignee_id IS NULL OR assignee_id = ?)"
+			args = append(args, newID)
+		}
+		res, err := tx.Exec(q, args...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return errLeadTaken
+		}
+		if err := addLeadEvent(tx, id, userID, leadEventAssign, assignee, login, note); err != nil {
+			return err
+		}
+		note = ""
+	}
+	if ch.Spam != nil && *ch.Spam != spam {
+		// Отмеченная вручную заявка — spam_reason manual (см. spamReason в spam.go)
+		reason := ""
+		if *ch.Spam {
+			reason = "manual"
+		}
+		if _, err := tx.Exec("UPDATE leads SET spam = ?, spam_reason = ? WHERE id = ?", *ch.Spam, reason, id); err != nil {
+			return err
+		}
+		if err := addLeadEvent(tx, id, userID, leadEventSpam, strconv.FormatBool(spam), strconv.FormatBool(*ch.Spam), note); err != nil {
+			return err
+		}
+		note = ""
+	}
+	if note != "" {
+		if err := addLeadEvent(tx, id, userID, leadEventNote, "", "", note); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func leadEvents(leadID int64) ([]LeadEvent, error) {
+	rows, err := db.Query(`SELECT e.id, e.type, e.old_value, e.new_value, e.note, IFNULL(e.user_id, 0), IFNULL(u.login, ''), e.created_at
+		FROM lead_events e LEFT JOIN users u ON u.id = e.user_id WHERE e.lead_id = ? ORDER BY e.created_at, e.id`, leadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []LeadEvent{}
+	for rows.Next() {
+		var e LeadEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.From, &e.To, &e.Note, &e.UserID, &e.User, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// writeLeadError — 404, 400 или 500 по ошибке updateLead/getLead.
+func writeLeadError(w http.ResponseWriter, err error) {
+	var bad badRequest
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.As(err, &bad):
+		http.Error(w, bad.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "DB error", http.StatusInternalServerError)
+	}
+}
+
+// /api/leads/{id}, /api/leads/{id}/notes, /api/leads/stats
+func handleLeadByID(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/leads/")
+	if rest == "stats" {
+		handleLeadStats(w, r)
+		return
+	}
+	idStr, sub, _ := strings.Cut(rest, "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 || (sub != "" && sub != "notes") {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case sub == "notes" && r.Method == http.MethodPost:
+		if !requirePermission(w, r, permLeadsManage) {
+			return
+		}
+		var req struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Text) == "" {
+			http.Error(w, "Text is required", http.StatusBadRequest)
+			return
+		}
+		if err := updateLead(id, currentUser(r), leadChange{Note: req.Text}); err != nil {
+			writeLeadError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"ok"}`))
+	case sub == "" && r.Method == http.MethodGet:
+		l, err := getLead(id)
+		if err != nil {
+			writeLeadError(w, err)
+			return
+		}
+		events, err := leadEvents(id)
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"lead": l, "timeline": events})
+	case sub == "" && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		if !requirePermission(w, r, permLeadsManage) {
+			return
+		}
+		var ch leadChange
+		if err := json.NewDecoder(r.Body).Decode(&ch); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := updateLead(id, currentUser(r), ch); err != nil {
+			writeLeadError(w, err)
+			return
+		}
+		l, err := getLead(id)
+		if err != nil {
+			writeLeadError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, l)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// --- STATS ---
+
+type leadStats struct {
+	Period     string         `json:"period,omitempty"`
+	AssigneeID int64          `json:"assignee_id,omitempty"`
+	Assignee   string         `json:"assignee,omitempty"`
+	Total      int            `json:"total"`
+	ByStatus   map[string]int `json:"by_status"`
+	Conversion float64        `json:"conversion"` // won / total
+	WinRate    float64        `json:"win_rate"`   // won / (won + lost)
+}
+
+func newLeadStats() *leadStats {
+	s := &leadStats{ByStatus: map[string]int{}}
+	for _, st := range leadStatuses {
+		s.ByStatus[st] = 0
+	}
+	return s
+}
+
+func (s *leadStats) add(status string, n int) {
+	s.Total += n
+	s.ByStatus[status] += n
+}
+
+func (s *leadStats) finish() {
+	ratio := func(a, b int) float64 {
+		if b == 0 {
+			return 0
+		}
+		return math.Round(float64(a)/float64(b)*10000) / 10000
+	}
+	won, lost := s.ByStatus[leadWon], s.ByStatus[leadLost]
+	s.Conversion = ratio(won, s.Total)
+	s.WinRate = ratio(won, won+lost)
+}
+
+var leadStatsGroups = map[string]string{
+	"day":   "%Y-%m-%d",
+	"week":  "%Y-W%W",
+	"month": "%Y-%m",
+}
+
+// GET /api/leads/stats — фильтры те же, что у /api/leads, плюс group=day|week|month.
+func handleLeadStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	group := r.URL.Query().Get("group")
+	if group == "" {
+		group = "month"
+	}
+	format, ok := leadStatsGroups[group]
+	if !ok {
+		http.Error(w, "Invalid group", http.StatusBadRequest)
+		return
+	}
+	f, err := parseLeadFilter(r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	where, args := f.where()
+	rows, err := db.Query(`SELECT strftime(?, leads.created_at, 'unixepoch', 'localtime'), IFNULL(leads.assignee_id, 0),
+		IFNULL(users.login, ''), leads.status, COUNT(*)
+		FROM leads LEFT JOIN users ON users.id = leads.assignee_id WHERE `+where+`
+		GROUP BY 1, 2, 3, 4 ORDER BY 1`, append([]interface{}{format}, args...)...)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	total := newLeadStats()
+	var periods, assignees []*leadStats
+	byPeriod := map[string]*leadStats{}
+	byAssignee := map[int64]*leadStats{}
+	for rows.Next() {
+		var period, login, status string
+		var assigneeID int64
+		var n int
+		if err := rows.Scan(&period, &assigneeID, &login, &status, &n); err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		p := byPeriod[period]
+		if p == nil {
+			p = newLeadStats()
+			p.Period = period
+			byPeriod[period] = p
+			periods = append(periods, p)
+		}
+		a := byAssignee[assigneeID]
+		if a == nil {
+			a = newLeadStats()
+			a.AssigneeID, a.Assignee = assigneeID, login
+			byAssignee[assigneeID] = a
+			assignees = append(assignees, a)
+		}
+		total.add(status, n)
+		p.add(status, n)
+		a.add(status, n)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	total.finish()
+	for _, s := range append(periods, assignees...) {
+		s.finish()
+	}
+	sort.SliceStable(assignees, func(i, j int) bool { return assignees[i].Total > assignees[j].Total })
+	if periods == nil {
+		periods = []*leadStats{}
+	}
+	if assignees == nil {
+		assignees = []*leadStats{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"group":     group,
+		"total":     total,
+		"periods":   periods,
+		"assignees": assignees,
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSVSafe(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"Иван", "Иван"},
+		{"=HYPERLINK(\"http://evil\")", "'=HYPERLINK(\"http://evil\")"},
+		{"@SUM(A1:A2)", "'@SUM(A1:A2)"},
+		{"\t=1+1", "'\t=1+1"},
+		{"\r=1+1", "'\r=1+1"},
+		{"+1+cmd|' /C calc'!A0", "'+1+cmd|' /C calc'!A0"},
+		{"-2+3", "'-2+3"},
+		{"-", "-"},
+		// Телефоны формулой не считаются
+		{"+998901234567", "+998901234567"},
+		{"+998 (90) 123-45-67", "+998 (90) 123-45-67"},
+		{"-10", "-10"},
+		{"a=1", "a=1"},
+	} {
+		if got := csvSafe(tc.in); got != tc.want {
+			t.Errorf("csvSafe(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestWriteLeadsCSV(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeLeadsCSV(w, []Lead{{ID: 7, Name: "=cmd()", Phone: "+998901234567", Description: "строка 1\nстрока 2, \"кавычки\"", Status: leadNew}})
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "\ufeff") {
+		t.Error("no UTF-8 BOM")
+	}
+	rows, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(body, "\ufeff"))).ReadAll()
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("rows %v, err %v", rows, err)
+	}
+	got := map[string]string{}
+	for i, col := range rows[0] {
+		got[col] = rows[1][i]
+	}
+	if got["id"] != "7" || got["name"] != "'=cmd()" || got["phone"] != "+998901234567" || got["description"] != "строка 1\nстрока 2, \"кавычки\"" {
+		t.Errorf("row %v", got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Общие помощники тестов: своя БД на тест и фейковый Bot API.
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// setupTestDB открывает пустую БД во временной папке и накатывает миграции.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	openTestDB(t)
+	if err := migrate(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openTestDB — пустая БД без миграций.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_fk=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := db
+	db = conn
+	t.Cleanup(func() {
+		conn.Close()
+		db = prev
+	})
+}
+
+func createTestLead(t *testing.T) *Lead {
+	t.Helper()
+	l := &Lead{Name: "Иван", Phone: "+998901234567", Status: leadNew, TelegramStatus: leadPending, CreatedAt: time.Now().Unix()}
+	if err := insertLead(db, l); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+type botCall struct {
+	Method string
+	Params url.Values
+}
+
+// fakeBotAPI — локальный Bot API: запоминает вызовы и отвечает ok,
+// если reply не вернул другой ответ.
+type fakeBotAPI struct {
+	*httptest.Server
+	mu    sync.Mutex
+	calls []botCall
+	reply func(method string) (status int, body string)
+}
+
+const testBotToken = "123:test"
+
+func newFakeBotAPI(t *testing.T) *fakeBotAPI {
+	t.Helper()
+	f := &fakeBotAPI{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, "/bot"+testBotToken+"/")
+		if method == r.URL.Path || r.ParseForm() != nil {
+			http.Error(w, `{"ok":false,"error_code":404,"description":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		f.mu.Lock()
+		f.calls = append(f.calls, botCall{Method: method, Params: r.PostForm})
+		reply := f.reply
+		f.mu.Unlock()
+		status, body := http.StatusOK, `{"ok":true,"result":{"message_id":1}}`
+		if reply != nil {
+			if s, b := reply(method); s != 0 {
+				status, body = s, b
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(f.Close)
+	t.Setenv("TELEGRAM_API_URL", f.URL)
+	t.Setenv("TELEGRAM_BOT_TOKEN", testBotToken)
+	return f
+}
+
+func (f *fakeBotAPI) setReply(reply func(method string) (int, string)) {
+	f.mu.Lock()
+	f.reply = reply
+	f.mu.Unlock()
+}
+
+// callsTo — вызовы метода method по порядку.
+func (f *fakeBotAPI) callsTo(method string) []botCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []botCall
+	for _, c := range f.calls {
+		if c.Method == method {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,668 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- MEDIA ---
+// Таблица media — по строке на файл в хранилище загрузок (URL, исходное имя, тип,
+// размер, sha256). media_refs — какие записи ссылаются на файл через img/images;
+// пересчитывается syncMediaRefs после сохранения записи и чистится триггером
+// при удалении. Файл без ссылок — сирота, его удаляет GC:
+//
+//	go run -tags sqlite_fts5 . media scan               зарегистрировать файлы с диска, пересчитать ссылки
+//	go run -tags sqlite_fts5 . media gc -dry-run        показать сирот
+//	go run -tags sqlite_fts5 . media gc [-min-age 24h]  удалить сирот
+//	go run -tags sqlite_fts5 . media dedupe [-dry-run]  перевести старые загрузки на имена по sha256
+//
+// MEDIA_GC_INTERVAL (например 24h) включает GC по расписанию в сервере.
+// min-age не меньше часа: свежая загрузка — сирота, пока запись не сохранена.
+
+const (
+	defaultMediaGCMinAge = 24 * time.Hour
+	minMediaGCMinAge     = time.Hour
+)
+
+type MediaRef struct {
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+}
+
+type Media struct {
+	Path         string     `json:"path"`
+	OriginalName string     `json:"original_name"`
+	ContentType  string     `json:"content_type"`
+	Size         int64      `json:"size"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	Hash         string     `json:"hash"`
+	CreatedAt    int64      `json:"created_at"`
+	Refs         []MediaRef `json:"refs"`
+}
+
+// isUploadPath: на файлы вне хранилища загрузок (статика, внешние URL) ссылки не считаем.
+func isUploadPath(p string) bool {
+	key, ok := storageKey(p)
+	return ok && !strings.HasPrefix(key, variantsPrefix)
+}
+
+// mediaPaths — загрузки, на которые ссылается запись.
+func mediaPaths(img string, images []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, p := range append([]string{img}, images...) {
+		if isUploadPath(p) && !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// syncMediaRefs переписывает ссылки записи на файлы.
+func (ct *ContentType) syncMediaRefs(q queryExecer, it *Item) error {
+	if _, err := q.Exec("DELETE FROM media_refs WHERE type = ? AND item_id = ?", ct.Name, it.ID); err != nil {
+		return err
+	}
+	for _, p := range mediaPaths(it.Img, it.Images) {
+		if _, err := q.Exec("INSERT OR IGNORE INTO media_refs (path, type, item_id) VALUES (?, ?, ?)", p, ct.Name, it.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildMediaRefs пересчитывает все ссылки по таблицам контента. Читает
+// только id, img и images: selectSQL зависит от более поздних миграций.
+func rebuildMediaRefs(q queryExecer) error {
+	if _, err := q.Exec("DELETE FROM media_refs"); err != nil {
+		return err
+	}
+	for _, ct := range contentTypes {
+		rows, err := q.Query("SELECT id, IFNULL(img,''), IFNULL(images,'') FROM " + ct.Table)
+		if err != nil {
+			return err
+		}
+		var items []*Item
+		for rows.Next() {
+			it := ct.newItem()
+			var imagesJSON string
+			if err := rows.Scan(&it.ID, &it.Img, &imagesJSON); err != nil {
+				rows.Close()
+				return err
+			}
+			it.Images, _, _ = parseImagesJSON([]byte(imagesJSON))
+			items = append(items, it)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, it := range items {
+			if err := ct.syncMediaRefs(q, it); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func backfillMediaRefs(tx *sql.Tx) error {
+	return rebuildMediaRefs(tx)
+}
+
+// refreshMediaRefs — rebuildMediaRefs одной транзакцией: пока таблица
+// пересобирается, GC (в том числе в другом процессе) видит прежние ссылки,
+// а не пустую media_refs, в которой сиротами выглядят все файлы.
+func refreshMediaRefs() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := rebuildMediaRefs(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// uploadPrefix — наносекундный префикс старых имён: 1754656656227111000_Снимок.png
+var uploadPrefix = regexp.MustCompile(`^\d{19}_`)
+
+// registerMedia описывает объект хранилища, которого ещё нет в media
+// (загрузки до появления таблицы).
+func registerMedia(obj StoredObject) error {
+	data, err := readStored(obj.Key)
+	if err != nil {
+		return err
+	}
+	p := storageURL(obj.Key)
+	m := Media{
+		Path:         p,
+		OriginalName: uploadPrefix.ReplaceAllString(path.Base(obj.Key), ""),
+		ContentType:  http.DetectContentType(data),
+		Size:         int64(len(data)),
+		Hash:         sha256Hex(data),
+		CreatedAt:    obj.ModTime.Unix(),
+	}
+	// Время загрузки точнее по префиксу имени, чем по mtime (см. backfillTimestamps)
+	if sub := uploadTimestamp.FindStringSubmatch(p); sub != nil {
+		if ns, err := strconv.ParseInt(sub[1], 10, 64); err == nil && ns/1e9 < m.CreatedAt {
+			m.CreatedAt = ns / 1e9
+		}
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		m.Width, m.Height = cfg.Width, cfg.Height
+	}
+	_, err = db.Exec(`INSERT OR IGNORE INTO media (path, original_name, content_type, size, width, height, hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.Path, m.OriginalName, m.ContentType, m.Size, m.Width, m.Height, m.Hash, m.CreatedAt)
+	return err
+}
+
+// scanMedia регистрирует незнакомые файлы хранилища, дописывает хэши,
+// убирает строки без файла и пересчитывает ссылки. Возвращает число новых файлов.
+func scanMedia() (int, error) {
+	known := map[string]string{}
+	rows, err := db.Query("SELECT path, hash FROM media")
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var p, hash string
+		if err := rows.Scan(&p, &hash); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		known[p] = hash
+	}
+	rows.Close()
+
+	objects, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, obj := range objects {
+		p := storageURL(obj.Key)
+		if !isUploadPath(p) {
+			continue
+		}
+		hash, ok := known[p]
+		delete(known, p)
+		switch {
+		case !ok:
+			if err := registerMedia(obj); err != nil {
+				return added, err
+			}
+			added++
+		case hash == "":
+			data, err := readStored(obj.Key)
+			if err != nil {
+				return added, err
+			}
+			if _, err := db.Exec("UPDATE media SET hash = ? WHERE path = ?", sha256Hex(data), p); err != nil {
+				return added, err
+			}
+		}
+	}
+	// Оставшиеся в known — строки, чьих файлов больше нет. Строки с чужим
+	// префиксом (после смены UPLOADS_PUBLIC_URL) не трогаем.
+	for p := range known {
+		if !isUploadPath(p) {
+			continue
+		}
+		if _, err := db.Exec("DELETE FROM media WHERE path = ?", p); err != nil {
+			return added, err
+		}
+	}
+	return added, refreshMediaRefs()
+}
+
+// mediaSize — размер загрузки по media; false, если файл не из хранилища.
+func mediaSize(p string) (int64, bool) {
+	var size int64
+	if err := db.QueryRow("SELECT size FROM media WHERE path = ?", p).Scan(&size); err != nil {
+		return 0, false
+	}
+	return size, true
+}
+
+type mediaGCReport struct {
+	DryRun bool    `json:"dry_run"`
+	Count  int     `json:"count"`
+	Bytes  int64   `json:"bytes"`
+	Files  []Media `json:"files"`
+}
+
+// removeMediaFile удаляет файл вместе с его вариантами (images.go).
+func removeMediaFile(p string) error {
+	var variantsJSON string
+	err := db.QueryRow("SELECT variants FROM image_variants WHERE src = ?", p).Scan(&variantsJSON)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	var variants []ImageVariant
+	_ = json.Unmarshal([]byte(variantsJSON), &variants)
+	for _, v := range variants {
+		if key, ok := storageKey(v.URL); ok {
+			if err := store.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	if key, ok := storageKey(p); ok {
+		if err := store.Delete(key); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec("DELETE FROM image_variants WHERE src = ?", p); err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM media WHERE path = ?", p)
+	return err
+}
+
+// collectMediaGarbage находит (и без dryRun удаляет) файлы без ссылок,
+// загруженные раньше minAge назад.
+func collectMediaGarbage(dryRun bool, minAge time.Duration) (*mediaGCReport, error) {
+	if _, err := scanMedia(); err != nil {
+		return nil, err
+	}
+	orphans, _, err := queryMedia(true, time.Now().Add(-minAge).Unix(), -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	rep := &mediaGCReport{DryRun: dryRun, Files: []Media{}}
+	for _, m := range orphans {
+		if !dryRun {
+			if err := removeMediaFile(m.Path); err != nil {
+				return rep, err
+			}
+		}
+		rep.Count++
+		rep.Bytes += m.Size
+		rep.Files = append(rep.Files, m)
+	}
+	return rep, nil
+}
+
+// queryMedia: orphaned — только файлы без ссылок; before > 0 — загруженные
+// раньше этого времени; limit < 0 — без ограничения.
+func queryMedia(orphaned bool, before int64, limit, offset int) ([]Media, int, error) {
+	where := "1 = 1"
+	var args []interface{}
+	if orphaned {
+		where += " AND NOT EXISTS (SELECT 1 FROM media_refs r WHERE r.path = media.path)"
+	}
+	if before > 0 {
+		where += " AND created_at < ?"
+		args = append(args, before)
+	}
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM media WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.Query(`SELECT path, original_name, content_type, size, width, height, hash, created_at
+		FROM media WHERE `+where+` ORDER BY created_at DESC, path LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	list := []Media{}
+	index := map[string]int{}
+	for rows.Next() {
+		var m Media
+		if err := rows.Scan(&m.Path, &m.OriginalName, &m.ContentType, &m.Size, &m.Width, &m.Height, &m.Hash, &m.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		m.Refs = []MediaRef{}
+		index[m.Path] = len(list)
+		list = append(list, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(list) == 0 {
+		return list, total, nil
+	}
+	refs, err := db.Query("SELECT path, type, item_id FROM media_refs ORDER BY type, item_id")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer refs.Close()
+	for refs.Next() {
+		var p string
+		var ref MediaRef
+		if err := refs.Scan(&p, &ref.Type, &ref.ID); err != nil {
+			return nil, 0, err
+		}
+		if i, ok := index[p]; ok {
+			list[i].Refs = append(list[i].Refs, ref)
+		}
+	}
+	return list, total, refs.Err()
+}
+
+// GET /api/media?orphaned=1&limit=&offset=
+func handleMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	limit, offset := 50, 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	orphaned := q.Get("orphaned") == "1" || q.Get("orphaned") == "true"
+	list, total, err := queryMedia(orphaned, 0, limit, offset)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": list, "total": total, "limit": limit, "offset": offset})
+}
+
+// POST /api/media/gc?dry_run=1&min_age=24h
+func handleMediaGC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	minAge := defaultMediaGCMinAge
+	if v := q.Get("min_age"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < minMediaGCMinAge {
+			http.Error(w, "Invalid min_age: must be at least "+minMediaGCMinAge.String(), http.StatusBadRequest)
+			return
+		}
+		minAge = d
+	}
+	dryRun := q.Get("dry_run") == "1" || q.Get("dry_run") == "true"
+	rep, err := collectMediaGarbage(dryRun, minAge)
+	if err != nil {
+		log.Println("Media GC error:", err)
+		http.Error(w, "GC error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// startMediaGC запускает GC по расписанию, если задан MEDIA_GC_INTERVAL.
+func startMediaGC() {
+	v := os.Getenv("MEDIA_GC_INTERVAL")
+	if v == "" {
+		return
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval <= 0 {
+		log.Println("Warning: invalid MEDIA_GC_INTERVAL:", v)
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			rep, err := collectMediaGarbage(false, defaultMediaGCMinAge)
+			if err != nil {
+				log.Println("[media] GC error:", err)
+				continue
+			}
+			if rep.Count > 0 {
+				log.Printf("[media] GC removed %d file(s), %d bytes", rep.Count, rep.Bytes)
+			}
+		}
+	}()
+}
+
+// --- DEDUPE ---
+// Загрузки до адресации по содержимому лежат под именами {unixnano}_{имя},
+// одни и те же байты — во многих копиях. dedupeMedia приводит их к
+// {sha256}.{ext} (uploads.go): копии сводятся к одному файлу, img/images в
+// таблицах контента переписываются. Повторный запуск ничего не меняет.
+
+type dedupeReport struct {
+	Moves map[string]string // старый путь -> новый
+	Files int               // файлов после
+	Rows  int               // переписанных записей
+}
+
+func copyStored(from, to string) error {
+	data, err := readStored(from)
+	if err != nil {
+		return err
+	}
+	return store.Put(to, bytes.NewReader(data), int64(len(data)), http.DetectContentType(data))
+}
+
+func dedupeMedia(dryRun bool) (*dedupeReport, error) {
+	if _, err := scanMedia(); err != nil {
+		return nil, err
+	}
+	all, _, err := queryMedia(false, 0, -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	rep := &dedupeReport{Moves: map[string]string{}}
+	targets := map[string]bool{}
+	created := map[string]Media{} // новый файл -> описание самой ранней копии
+	// queryMedia отдаёт новые первыми, идём от старых
+	for i := len(all) - 1; i >= 0; i-- {
+		m := all[i]
+		if m.Hash == "" {
+			continue
+		}
+		ext := uploadTypes[m.ContentType]
+		if ext == "" {
+			ext = strings.ToLower(filepath.Ext(m.Path))
+		}
+		target := storageURL(m.Hash + ext)
+		if m.Path == target {
+			targets[target] = true
+			continue
+		}
+		rep.Moves[m.Path] = target
+		if !targets[target] {
+			targets[target] = true
+			created[target] = m
+		}
+	}
+	rep.Files = len(targets)
+	if dryRun || len(rep.Moves) == 0 {
+		return rep, nil
+	}
+
+	// Старые файлы удаляются только после того, как записи перестали на них ссылаться
+	var paths []string
+	for target, m := range created {
+		from, _ := storageKey(m.Path)
+		to, _ := storageKey(target)
+		if err := copyStored(from, to); err != nil {
+			return nil, err
+		}
+		_, err := db.Exec(`INSERT OR IGNORE INTO media (path, original_name, content_type, size, width, height, hash, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			target, m.OriginalName, m.ContentType, m.Size, m.Width, m.Height, m.Hash, m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, target)
+	}
+	if rep.Rows, err = rewriteContentImages(rep.Moves); err != nil {
+		return nil, err
+	}
+	if err := refreshMediaRefs(); err != nil {
+		return nil, err
+	}
+	for old := range rep.Moves {
+		if err := removeMediaFile(old); err != nil {
+			return nil, err
+		}
+	}
+	processUploads(paths)
+	return rep, nil
+}
+
+// rewriteContentImages заменяет пути в img/images всех таблиц контента.
+func rewriteContentImages(moves map[string]string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	count := 0
+	for _, ct := range contentTypes {
+		n, err := rewriteImagesColumn(tx, ct, func(it *Item) bool {
+			changed := false
+			if to, ok := moves[it.Img]; ok {
+				it.Img, changed = to, true
+			}
+			for i, p := range it.Images {
+				if to, ok := moves[p]; ok {
+					if m, ok := it.ImageMeta[p]; ok {
+						delete(it.ImageMeta, p)
+						m.URL = to
+						it.ImageMeta[to] = m
+					}
+					it.Images[i], changed = to, true
+				}
+			}
+			it.Images = uniqueStrings(it.Images)
+			return changed
+		})
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, tx.Commit()
+}
+
+// rewriteImagesColumn передаёт fn img/images каждой записи типа и сохраняет
+// те, для которых fn вернула true. updated_at не меняется: смена адреса или
+// формата хранения картинки — не правка записи. Читает только нужные колонки,
+// поэтому годится и для миграций.
+func rewriteImagesColumn(q queryExecer, ct *ContentType, fn func(it *Item) bool) (int, error) {
+	rows, err := q.Query("SELECT id, IFNULL(img,''), IFNULL(images,''), updated_at FROM " + ct.Table)
+	if err != nil {
+		return 0, err
+	}
+	type change struct {
+		it        *Item
+		updatedAt sql.NullInt64
+	}
+	var changes []change
+	for rows.Next() {
+		c := change{it: ct.newItem()}
+		var imagesJSON string
+		if err := rows.Scan(&c.it.ID, &c.it.Img, &imagesJSON, &c.updatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if imagesJSON != "" {
+			// Битый JSON не трогаем, чтобы не потерять картинки
+			if c.it.Images, c.it.ImageMeta, err = parseImagesJSON([]byte(imagesJSON)); err != nil {
+				continue
+			}
+		}
+		if fn(c.it) {
+			changes = append(changes, c)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, c := range changes {
+		if _, err := q.Exec("UPDATE "+ct.Table+" SET img = ?, images = ? WHERE id = ?", c.it.Img, c.it.imagesJSON(), c.it.ID); err != nil {
+			return 0, err
+		}
+		// Триггер *_timestamps_au только что выставил updated_at = now — возвращаем прежнее
+		if _, err := q.Exec("UPDATE "+ct.Table+" SET updated_at = ? WHERE id = ?", c.updatedAt, c.it.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(changes), nil
+}
+
+// runMediaCommand: media scan | media gc [-dry-run] [-min-age 24h] | media dedupe [-dry-run]
+func runMediaCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: media scan | media gc [-dry-run] [-min-age 24h] | media dedupe [-dry-run]")
+	}
+	switch args[0] {
+	case "scan":
+		added, err := scanMedia()
+		if err != nil {
+			return err
+		}
+		log.Printf("[media] registered %d new file(s)", added)
+		return nil
+	case "gc":
+		fs := flag.NewFlagSet("media gc", flag.ContinueOnError)
+		dryRun := fs.Bool("dry-run", false, "только показать файлы без ссылок")
+		minAge := fs.Duration("min-age", defaultMediaGCMinAge, "не трогать файлы моложе")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *minAge < minMediaGCMinAge {
+			return fmt.Errorf("-min-age must be at least %s", minMediaGCMinAge)
+		}
+		rep, err := collectMediaGarbage(*dryRun, *minAge)
+		if err != nil {
+			return err
+		}
+		for _, m := range rep.Files {
+			fmt.Printf("%s\t%d\t%s\n", m.Path, m.Size, m.OriginalName)
+		}
+		verb := "removed"
+		if rep.DryRun {
+			verb = "would remove"
+		}
+		log.Printf("[media] %s %d file(s), %d bytes", verb, rep.Count, rep.Bytes)
+		return nil
+	case "dedupe":
+		fs := flag.NewFlagSet("media dedupe", flag.ContinueOnError)
+		dryRun := fs.Bool("dry-run", false, "только показать переименования")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		rep, err := dedupeMedia(*dryRun)
+		if err != nil {
+			return err
+		}
+		for from, to := range rep.Moves {
+			fmt.Printf("%s\t%s\n", from, to)
+		}
+		log.Printf("[media] %d file(s) -> %d, rewrote %d row(s)", len(rep.Moves), rep.Files, rep.Rows)
+		return nil
+	}
+	return fmt.Errorf("unknown media command %q", args[0])
+}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- MIGRATIONS ---
+// Схема БД описывается пронумерованными up-миграциями. Применённые миграции
+// записываются в schema_migrations вместе с контрольной суммой; каждая
+// миграция выполняется в своей транзакции. Уже применённую миграцию менять
+// нельзя — только добавлять новую с большим номером.
+//
+// Миграции применяются при старте (если AUTO_MIGRATE != "0") или командой:
+//
+//	go run -tags sqlite_fts5 . migrate            применить все ожидающие
+//	go run -tags sqlite_fts5 . migrate -dry-run   выполнить и откатить, ничего не сохраняя
+//	go run -tags sqlite_fts5 . migrate status     показать применённые и ожидающие
+//
+// Поисковому индексу нужен модуль fts5 (тег sqlite_fts5, см. search.go). В
+// сборке без него такие миграции откладываются и применяются при первом
+// запуске сборки с тегом, а поиск до тех пор работает через LIKE.
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string                 // выполняется первым, если не пустой
+	Up      func(tx *sql.Tx) error // шаги на Go: идемпотентные ALTER, бэкфиллы данных
+	FTS5    bool                   // нужен модуль fts5; без него миграция откладывается
+}
+
+// checksum покрывает номер, имя и SQL; код Up-функции в сумму не входит.
+func (m Migration) checksum() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\n%s\n%s", m.Version, m.Name, strings.TrimSpace(m.SQL))))
+	return hex.EncodeToString(sum[:])
+}
+
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create content tables",
+		SQL: `
+CREATE TABLE IF NOT EXISTS blog (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	img TEXT,
+	title TEXT,
+	title_uz TEXT,
+	title_en TEXT,
+	description TEXT,
+	description_uz TEXT,
+	description_en TEXT
+);
+CREATE TABLE IF NOT EXISTS projects (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	img TEXT,
+	title TEXT,
+	title_uz TEXT,
+	title_en TEXT,
+	description TEXT,
+	description_uz TEXT,
+	description_en TEXT
+);
+CREATE TABLE IF NOT EXISTS led (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	img TEXT,
+	title TEXT,
+	title_uz TEXT,
+	title_en TEXT,
+	description TEXT,
+	description_uz TEXT,
+	description_en TEXT,
+	location TEXT,
+	images TEXT
+);`,
+	},
+	{
+		// Бывшие вызовы ensureColumn: в старых базах часть колонок уже есть
+		Version: 2,
+		Name:    "add images, links and translation columns",
+		Up: addColumns(
+			"blog.images", "blog.links", "blog.title_uz", "blog.title_en", "blog.description_uz", "blog.description_en",
+			"projects.images", "projects.links", "projects.title_uz", "projects.title_en", "projects.description_uz", "projects.description_en",
+			"led.images", "led.title_uz", "led.title_en", "led.description_uz", "led.description_en",
+		),
+	},
+	{
+		Version: 3,
+		Name:    "create users and sessions",
+		SQL: `
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	login TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	role TEXT NOT NULL DEFAULT 'admin'
+);
+CREATE TABLE IF NOT EXISTS sessions (
+	token_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at INTEGER NOT NULL,
+	created_at INTEGER NOT NULL
+);`,
+		// users без role мог создать старый initAuthTables
+		Up: func(tx *sql.Tx) error {
+			return ensureColumn(tx, "users", "role", "TEXT NOT NULL DEFAULT 'admin'")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "index sessions",
+		SQL: `
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);`,
+	},
+	{
+		Version: 5,
+		Name:    "create search index",
+		SQL:     searchIndexSQL(true, "blog", "projects", "led"),
+		FTS5:    true,
+	},
+	{
+		Version: 6,
+		Name:    "add slugs",
+		SQL: `
+CREATE TABLE IF NOT EXISTS slugs (
+	type TEXT NOT NULL,
+	slug TEXT NOT NULL,
+	item_id INTEGER NOT NULL,
+	lang TEXT NOT NULL,
+	current INTEGER NOT NULL DEFAULT 1,
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY (type, slug)
+);
+CREATE INDEX IF NOT EXISTS idx_slugs_item ON slugs(type, item_id);
+ALTER TABLE blog ADD COLUMN slug TEXT;
+ALTER TABLE blog ADD COLUMN slug_uz TEXT;
+ALTER TABLE blog ADD COLUMN slug_en TEXT;
+ALTER TABLE projects ADD COLUMN slug TEXT;
+ALTER TABLE projects ADD COLUMN slug_uz TEXT;
+ALTER TABLE projects ADD COLUMN slug_en TEXT;
+CREATE TRIGGER IF NOT EXISTS blog_slugs_ad AFTER DELETE ON blog BEGIN
+	DELETE FROM slugs WHERE type = 'blog' AND item_id = old.id;
+END;
+CREATE TRIGGER IF NOT EXISTS projects_slugs_ad AFTER DELETE ON projects BEGIN
+	DELETE FROM slugs WHERE type = 'projects' AND item_id = old.id;
+END;`,
+		Up: backfillSlugs("blog", "projects"),
+	},
+	{
+		Version: 7,
+		Name:    "add content timestamps",
+		SQL:     timestampsSQL("blog", "projects", "led"),
+		Up:      backfillTimestamps("blog", "projects", "led"),
+	},
+	{
+		Version: 8,
+		Name:    "create image variants",
+		SQL: `
+CREATE TABLE IF NOT EXISTS image_variants (
+	src TEXT PRIMARY KEY,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	variants TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);`,
+	},
+	{
+		Version: 9,
+		Name:    "create media",
+		SQL: `
+CREATE TABLE IF NOT EXISTS media (
+	path TEXT PRIMARY KEY,
+	original_name TEXT NOT NULL DEFAULT '',
+	content_type TEXT NOT NULL DEFAULT '',
+	size INTEGER NOT NULL DEFAULT 0,
+	width INTEGER NOT NULL DEFAULT 0,
+	height INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL
+);`,
+	},
+	{
+		Version: 10,
+		Name:    "add media hash and refs",
+		SQL: `
+ALTER TABLE media ADD COLUMN hash TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_media_hash ON media(hash);
+CREATE TABLE IF NOT EXISTS media_refs (
+	path TEXT NOT NULL,
+	type TEXT NOT NULL,
+	item_id INTEGER NOT NULL,
+	PRIMARY KEY (path, type, item_id)
+);
+CREATE INDEX IF NOT EXISTS idx_media_refs_item ON media_refs(type, item_id);
+CREATE TRIGGER IF NOT EXISTS blog_media_ad AFTER DELETE ON blog BEGIN
+	DELETE FROM media_refs WHERE type = 'blog' AND item_id = old.id;
+END;
+CREATE TRIGGER IF NOT EXISTS projects_media_ad AFTER DELETE ON projects BEGIN
+	DELETE FROM media_refs WHERE type = 'projects' AND item_id = old.id;
+END;
+CREATE TRIGGER IF NOT EXISTS led_media_ad AFTER DELETE ON led BEGIN
+	DELETE FROM media_refs WHERE type = 'led' AND item_id = old.id;
+END;`,
+		Up: backfillMediaRefs,
+	},
+	{
+		Version: 11,
+		Name:    "store images as objects",
+		Up:      migrateImagesToObjects("blog", "projects", "led"),
+	},
+	{
+		Version: 12,
+		Name:    "create leads",
+		SQL: `
+CREATE TABLE IF NOT EXISTS leads (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL DEFAULT '',
+	phone TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	referrer TEXT NOT NULL DEFAULT '',
+	page TEXT NOT NULL DEFAULT '',
+	telegram_status TEXT NOT NULL DEFAULT 'pending',
+	telegram_error TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_leads_created ON leads(created_at);`,
+	},
+	{
+		Version: 13,
+		Name:    "create outbox",
+		SQL: `
+CREATE TABLE IF NOT EXISTS outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	channel TEXT NOT NULL,
+	lead_id INTEGER NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at INTEGER NOT NULL,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	sent_at INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox(status, next_attempt_at);`,
+	},
+	{
+		Version: 14,
+		Name:    "add leads spam flag",
+		SQL: `
+ALTER TABLE leads ADD COLUMN spam INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE leads ADD COLUMN spam_reason TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		Version: 15,
+		Name:    "add leads service",
+		SQL:     `ALTER TABLE leads ADD COLUMN service TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		Version: 16,
+		Name:    "add lead pipeline",
+		SQL: `
+ALTER TABLE leads ADD COLUMN status TEXT NOT NULL DEFAULT 'new';
+ALTER TABLE leads ADD COLUMN assignee_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_leads_status ON leads(status);
+CREATE INDEX IF NOT EXISTS idx_leads_assignee ON leads(assignee_id);
+CREATE TABLE IF NOT EXISTS lead_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	lead_id INTEGER NOT NULL REFERENCES leads(id) ON DELETE CASCADE,
+	user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	type TEXT NOT NULL,
+	old_value TEXT NOT NULL DEFAULT '',
+	new_value TEXT NOT NULL DEFAULT '',
+	note TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_lead_events_lead ON lead_events(lead_id, created_at);`,
+	},
+	{
+		Version: 17,
+		Name:    "link users to telegram",
+		SQL: `
+ALTER TABLE users ADD COLUMN telegram_id INTEGER;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_telegram ON users(telegram_id) WHERE telegram_id IS NOT NULL;`,
+	},
+	{
+		// Старые ключи вариантов не различали a.jpg и a.png — описания
+		// сбрасываются, и фоновый воркер строит варианты заново под новыми ключами
+		Version: 18,
+		Name:    "reset image variants",
+		SQL:     `DELETE FROM image_variants;`,
+	},
+}
+
+// addColumns добавляет TEXT-колонки вида "table.column", если их ещё нет.
+func addColumns(specs ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, spec := range specs {
+			table, column, _ := strings.Cut(spec, ".")
+			if err := ensureColumn(tx, table, column, "TEXT"); err != nil {
+				return fmt.Errorf("%s: %w", spec, err)
+			}
+		}
+		return nil
+	}
+}
+
+// timestampsSQL добавляет created_at/updated_at (unix-время) и триггеры,
+// которые их выставляют, — как и поисковый индекс, не зависит от обработчиков.
+func timestampsSQL(tables ...string) string {
+	var b strings.Builder
+	for _, t := range tables {
+		fmt.Fprintf(&b, "ALTER TABLE %s ADD COLUMN created_at INTEGER;\n", t)
+		fmt.Fprintf(&b, "ALTER TABLE %s ADD COLUMN updated_at INTEGER;\n", t)
+		fmt.Fprintf(&b, `CREATE TRIGGER IF NOT EXISTS %[1]s_timestamps_ai AFTER INSERT ON %[1]s BEGIN
+	UPDATE %[1]s SET created_at = IFNULL(new.created_at, strftime('%%s','now')), updated_at = strftime('%%s','now') WHERE id = new.id;
+END;
+CREATE TRIGGER IF NOT EXISTS %[1]s_timestamps_au AFTER UPDATE ON %[1]s WHEN new.updated_at IS old.updated_at BEGIN
+	UPDATE %[1]s SET updated_at = strftime('%%s','now') WHERE id = new.id;
+END;
+`, t)
+	}
+	return b.String()
+}
+
+// Загрузки называются "<unixnano>_<имя>", так что для старых записей время
+// первой картинки — лучшая оценка даты создания.
+var uploadTimestamp = regexp.MustCompile(`/(\d{19})_[^/]*$`)
+
+func backfillTimestamps(tables ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		now := time.Now().Unix()
+		for _, t := range tables {
+			rows, err := tx.Query("SELECT id, IFNULL(img,'') FROM " + t + " WHERE created_at IS NULL")
+			if err != nil {
+				return err
+			}
+			stamps := map[int]int64{}
+			for rows.Next() {
+				var id int
+				var img string
+				if err := rows.Scan(&id, &img); err != nil {
+					rows.Close()
+					return err
+				}
+				stamps[id] = now
+				if m := uploadTimestamp.FindStringSubmatch(img); m != nil {
+					if ns, err := strconv.ParseInt(m[1], 10, 64); err == nil && ns/1e9 < now {
+						stamps[id] = ns / 1e9
+					}
+				}
+			}
+			rows.Close()
+			for id, ts := range stamps {
+				if _, err := tx.Exec("UPDATE "+t+" SET created_at = ?, updated_at = ? WHERE id = ?", ts, ts, id); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+type queryExecer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func ensureColumn(q queryExecer, table string, column string, columnType string) error {
+	present, err := columnExists(q, table, column)
+	if err != nil || present {
+		return err
+	}
+	_, err = q.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, columnType))
+	return err
+}
+
+func columnExists(q queryExecer, table string, column string) (bool, error) {
+	rows, err := q.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid int
+		var name, ctype string
+		var notnull, pk int
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err == nil && name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+type appliedMigration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt int64
+}
+
+func loadAppliedMigrations() (map[int]appliedMigration, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[a.Version] = a
+	}
+	return applied, rows.Err()
+}
+
+// pendingMigrations проверяет контрольные суммы применённых миграций
+// и возвращает ещё не применённые по возрастанию номера.
+func pendingMigrations() ([]Migration, error) {
+	applied, err := loadAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	var pending []Migration
+	for i, m := range sorted {
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("duplicate migration version %d", m.Version)
+		}
+		a, ok := applied[m.Version]
+		if !ok {
+			pending = append(pending, m)
+			continue
+		}
+		if a.Checksum != m.checksum() {
+			return nil, fmt.Errorf("migration %d (%s) was modified after being applied: checksum %s, expected %s", m.Version, m.Name, m.checksum(), a.Checksum)
+		}
+	}
+	return pending, nil
+}
+
+func applyMigration(tx *sql.Tx, m Migration) error {
+	if strings.TrimSpace(m.SQL) != "" {
+		if _, err := tx.Exec(m.SQL); err != nil {
+			return err
+		}
+	}
+	if m.Up != nil {
+		if err := m.Up(tx); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec("INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)", m.Version, m.Name, m.checksum(), time.Now().Unix())
+	return err
+}
+
+// migrate применяет ожидающие миграции. В режиме dryRun все они выполняются
+// в одной транзакции, которая затем откатывается.
+func migrate(dryRun bool) error {
+	pending, err := pendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		log.Println("[migrate] Schema is up to date")
+		return nil
+	}
+	if dryRun {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		applied, skipped := 0, 0
+		for _, m := range pending {
+			if skipMigration(m) {
+				skipped++
+				continue
+			}
+			if err := applyMigration(tx, m); err != nil {
+				return migrationError(m, err)
+			}
+			applied++
+			log.Printf("[migrate] Would apply %d: %s", m.Version, m.Name)
+		}
+		log.Printf("[migrate] Dry run: %d migration(s) ran cleanly and were rolled back, %d skipped", applied, skipped)
+		return nil
+	}
+	for _, m := range pending {
+		if skipMigration(m) {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := applyMigration(tx, m); err != nil {
+			tx.Rollback()
+			return migrationError(m, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		log.Printf("[migrate] Applied %d: %s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// skipMigration — миграцию нельзя применить в этой сборке (нет fts5); она
+// остаётся ожидающей до запуска сборки с тегом.
+func skipMigration(m Migration) bool {
+	if !m.FTS5 || fts5Available() {
+		return false
+	}
+	log.Printf("[migrate] Skipping %d (%s): SQLite is built without fts5, search uses LIKE (build with -tags sqlite_fts5)", m.Version, m.Name)
+	return true
+}
+
+func migrationError(m Migration, err error) error {
+	if strings.Contains(err.Error(), "no such module: fts5") {
+		return fmt.Errorf("migration %d (%s): %w (rebuild with -tags sqlite_fts5)", m.Version, m.Name, err)
+	}
+	return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+}
+
+// runMigrateCommand реализует подкоманду `migrate`.
+func runMigrateCommand(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "apply pending migrations in a transaction and roll it back")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.Arg(0) == "status" {
+		return printMigrationStatus()
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unknown migrate argument %q", fs.Arg(0))
+	}
+	return migrate(*dryRun)
+}
+
+func printMigrationStatus() error {
+	applied, err := loadAppliedMigrations()
+	if err != nil {
+		return err
+	}
+	pending, err := pendingMigrations()
+	if err != nil {
+		return err
+	}
+	isPending := map[int]bool{}
+	for _, m := range pending {
+		isPending[m.Version] = true
+	}
+	for _, m := range migrations {
+		if isPending[m.Version] {
+			fmt.Fprintf(os.Stdout, "%4d  pending                    %s\n", m.Version, m.Name)
+			continue
+		}
+		at := time.Unix(applied[m.Version].AppliedAt, 0).Format("2006-01-02 15:04:05")
+		fmt.Fprintf(os.Stdout, "%4d  applied %s  %s\n", m.Version, at, m.Name)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+// useMigrations подменяет список миграций на время теста.
+func useMigrations(t *testing.T, list []Migration) {
+	t.Helper()
+	prev := migrations
+	migrations = list
+	t.Cleanup(func() { migrations = prev })
+}
+
+func TestMigrationChecksum(t *testing.T) {
+	base := Migration{Version: 7, Name: "add things", SQL: "CREATE TABLE things (id INTEGER)"}
+	for _, tc := range []struct {
+		name string
+		m    Migration
+		same bool
+	}{
+		{"identical", base, true},
+		{"surrounding whitespace", Migration{Version: 7, Name: "add things", SQL: "\n\tCREATE TABLE things (id INTEGER)\n"}, true},
+		// Код Up в сумму не входит
+		{"up func", Migration{Version: 7, Name: "add things", SQL: base.SQL, Up: func(*sql.Tx) error { return nil }}, true},
+		{"version", Migration{Version: 8, Name: "add things", SQL: base.SQL}, false},
+		{"name", Migration{Version: 7, Name: "add stuff", SQL: base.SQL}, false},
+		{"sql", Migration{Version: 7, Name: "add things", SQL: "CREATE TABLE things (id TEXT)"}, false},
+	} {
+		if got := tc.m.checksum() == base.checksum(); got != tc.same {
+			t.Errorf("%s: same checksum = %v, want %v", tc.name, got, tc.same)
+		}
+	}
+}
+
+func TestMigrateOrdering(t *testing.T) {
+	openTestDB(t)
+	var order []int
+	step := func(v int) Migration {
+		return Migration{
+			Version: v,
+			Name:    fmt.Sprintf("step %d", v),
+			SQL:     fmt.Sprintf("CREATE TABLE step%d (id INTEGER)", v),
+			Up: func(tx *sql.Tx) error {
+				order = append(order, v)
+				return nil
+			},
+		}
+	}
+	// Порядок в списке не важен — применяется по возрастанию номера
+	useMigrations(t, []Migration{step(3), step(1), step(2)})
+	if err := migrate(false); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(order) != "[1 2 3]" {
+		t.Errorf("applied in order %v", order)
+	}
+	if pending, err := pendingMigrations(); err != nil || len(pending) != 0 {
+		t.Fatalf("pending after migrate: %v, %v", pending, err)
+	}
+
+	// Повторный запуск ничего не делает, новая миграция применяется одна
+	order = nil
+	migrations = append(migrations, step(4))
+	if err := migrate(false); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(order) != "[4]" {
+		t.Errorf("second run applied %v", order)
+	}
+
+	for _, tc := range []struct {
+		name string
+		list []Migration
+		want string
+	}{
+		{"duplicate version", []Migration{step(1), step(2), step(2), step(3), step(4)}, "duplicate migration version 2"},
+		{"modified sql", []Migration{step(1), {Version: 2, Name: "step 2", SQL: "CREATE TABLE other (id INTEGER)"}, step(3), step(4)}, "migration 2 (step 2) was modified"},
+		{"renamed", []Migration{step(1), step(2), {Version: 3, Name: "step three", SQL: step(3).SQL}, step(4)}, "migration 3 (step three) was modified"},
+	} {
+		migrations = tc.list
+		order = nil
+		err := migrate(false)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+		if len(order) != 0 {
+			t.Errorf("%s: applied %v despite the error", tc.name, order)
+		}
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	openTestDB(t)
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+
+	if err := migrate(true); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&n)
+	if n != 0 {
+		t.Errorf("dry run recorded %d migration(s)", n)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM blog").Scan(&n); err == nil {
+		t.Error("dry run left the blog table behind")
+	}
+
+	// Отложенные без fts5 миграции не считаются выполненными
+	skipped := 0
+	for _, m := range migrations {
+		if m.FTS5 && !fts5Available() {
+			skipped++
+		}
+	}
+	want := fmt.Sprintf("Dry run: %d migration(s) ran cleanly and were rolled back, %d skipped", len(migrations)-skipped, skipped)
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("log does not contain %q:\n%s", want, buf.String())
+	}
+
+	if err := migrate(false); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := pendingMigrations()
+	if err != nil || len(pending) != skipped {
+		t.Errorf("pending after migrate: %d, %v; want %d", len(pending), err, skipped)
+	}
+}
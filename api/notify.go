@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- NOTIFICATION CHANNELS ---
+// Куда уходят заявки. Каждая заявка проходит по правилам маршрутизации и для
+// каждого выбранного канала ставится отдельное сообщение в outbox (outbox.go),
+// так что сбой одного канала не мешает остальным.
+//
+// Полная настройка — JSON-файл NOTIFY_CONFIG:
+//
+//	{
+//	  "channels": {
+//	    "sales":  {"type": "telegram", "chat_id": "-1001"},
+//	    "led":    {"type": "telegram", "chat_id": "-1002"},
+//	    "office": {"type": "email", "to": ["sales@example.com"]},
+//	    "crm":    {"type": "webhook", "url": "https://crm.example.com/hook", "secret": "..."}
+//	  },
+//	  "routes": [
+//	    {"match": {"service": ["led"]}, "channels": ["led", "office"]},
+//	    {"match": {"keywords": ["блогер", "influencer"], "page": ["/contact*"]}, "channels": ["sales"]},
+//	    {"channels": ["sales", "crm"]}
+//	  ]
+//	}
+//
+// Правила проверяются по порядку, срабатывает первое подходящее; внутри match
+// условия объединяются через И, значения списка — через ИЛИ (page — шаблон
+// path.Match по пути страницы, keywords — подстрока имени или описания без
+// учёта регистра). Правило без match подходит всем.
+//
+// Без NOTIFY_CONFIG каналы собираются из env, и все заявки идут во все:
+//
+//	TELEGRAM_CHAT_ID=-1001,-1002                 чаты Telegram (бот — TELEGRAM_BOT_TOKEN)
+//	LEAD_EMAIL_TO=a@example.com,b@example.com   письма через SMTP
+//	LEAD_WEBHOOK_URL, LEAD_WEBHOOK_SECRET        вебхук
+//
+// SMTP: SMTP_HOST, SMTP_PORT (587; 465 — сразу TLS), SMTP_USER, SMTP_PASSWORD, SMTP_FROM.
+// Вебхук получает POST с JSON {"event": "lead.created", "lead": {...}} и заголовками
+// X-Webhook-Timestamp и X-Webhook-Signature: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body)).
+
+const (
+	channelTelegram = "telegram"
+	channelEmail    = "email"
+	channelWebhook  = "webhook"
+)
+
+type notifyChannel struct {
+	Name   string   `json:"-"`
+	Type   string   `json:"type"`
+	ChatID string   `json:"chat_id,omitempty"` // telegram
+	To     []string `json:"to,omitempty"`      // email
+	URL    string   `json:"url,omitempty"`     // webhook
+	Secret string   `json:"secret,omitempty"`  // webhook
+}
+
+type notifyMatch struct {
+	Service  []string `json:"service"`
+	Page     []string `json:"page"`
+	Keywords []string `json:"keywords"`
+}
+
+type notifyRoute struct {
+	Match    *notifyMatch `json:"match"`
+	Channels []string     `json:"channels"`
+}
+
+type notifyConfig struct {
+	Channels map[string]*notifyChannel `json:"channels"`
+	Routes   []notifyRoute             `json:"routes"`
+}
+
+var notify = &notifyConfig{Channels: map[string]*notifyChannel{}}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// initNotify читает NOTIFY_CONFIG или собирает каналы из env.
+func initNotify() error {
+	cfg := &notifyConfig{Channels: map[string]*notifyChannel{}}
+	if file := os.Getenv("NOTIFY_CONFIG"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	} else {
+		cfg = notifyConfigFromEnv()
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	notify = cfg
+	names := make([]string, 0, len(cfg.Channels))
+	for name, c := range cfg.Channels {
+		names = append(names, name+"("+c.Type+")")
+	}
+	log.Printf("Lead notifications: %d channel(s) %s, %d route(s)", len(names), strings.Join(names, " "), len(cfg.Routes))
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func notifyConfigFromEnv() *notifyConfig {
+	cfg := &notifyConfig{Channels: map[string]*notifyChannel{}}
+	var all []string
+	add := func(name string, c *notifyChannel) {
+		cfg.Channels[name] = c
+		all = append(all, name)
+	}
+	if os.Getenv("TELEGRAM_BOT_TOKEN") != "" {
+		// Первый чат называется "telegram", как в сообщениях до появления каналов
+		for i, chat := range splitList(os.Getenv("TELEGRAM_CHAT_ID")) {
+			name := channelTelegram
+			if i > 0 {
+				name += strconv.Itoa(i + 1)
+			}
+			add(name, &notifyChannel{Type: channelTelegram, ChatID: chat})
+		}
+	}
+	if to := splitList(os.Getenv("LEAD_EMAIL_TO")); len(to) > 0 {
+		add(channelEmail, &notifyChannel{Type: channelEmail, To: to})
+	}
+	if u := os.Getenv("LEAD_WEBHOOK_URL"); u != "" {
+		add(channelWebhook, &notifyChannel{Type: channelWebhook, URL: u, Secret: os.Getenv("LEAD_WEBHOOK_SECRET")})
+	}
+	if len(all) > 0 {
+		cfg.Routes = []notifyRoute{{Channels: all}}
+	}
+	return cfg
+}
+
+func (cfg *notifyConfig) validate() error {
+	for name, c := range cfg.Channels {
+		c.Name = name
+		switch c.Type {
+		case channelTelegram:
+			if c.ChatID == "" {
+				return fmt.Errorf("channel %s: chat_id is required", name)
+			}
+			if os.Getenv("TELEGRAM_BOT_TOKEN") == "" {
+				return fmt.Errorf("channel %s: TELEGRAM_BOT_TOKEN is not set", name)
+			}
+		case channelEmail:
+			if len(c.To) == 0 {
+				return fmt.Errorf("channel %s: to is required", name)
+			}
+			if os.Getenv("SMTP_HOST") == "" {
+				return fmt.Errorf("channel %s: SMTP_HOST is not set", name)
+			}
+		case channelWebhook:
+			if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("channel %s: invalid url %q", name, c.URL)
+			}
+		default:
+			return fmt.Errorf("channel %s: unknown type %q", name, c.Type)
+		}
+	}
+	for i, r := range cfg.Routes {
+		if len(r.Channels) == 0 {
+			return fmt.Errorf("route %d: no channels", i+1)
+		}
+		for _, name := range r.Channels {
+			if cfg.Channels[name] == nil {
+				return fmt.Errorf("route %d: unknown channel %q", i+1, name)
+			}
+		}
+		if r.Match != nil {
+			for _, p := range r.Match.Page {
+				if _, err := path.Match(p, ""); err != nil {
+					return fmt.Errorf("route %d: bad page pattern %q", i+1, p)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (m *notifyMatch) matches(l *Lead) bool {
+	if m == nil {
+		return true
+	}
+	if len(m.Service) > 0 && indexOf(m.Service, l.Service) < 0 {
+		return false
+	}
+	if len(m.Page) > 0 {
+		p := l.Page
+		if u, err := url.Parse(l.Page); err == nil {
+			p = u.Path
+		}
+		found := false
+		for _, pattern := range m.Page {
+			if ok, _ := path.Match(pattern, p); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(m.Keywords) > 0 {
+		text := strings.ToLower(l.Name + "\n" + l.Description)
+		found := false
+		for _, k := range m.Keywords {
+			if strings.Contains(text, strings.ToLower(k)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// routeLead — каналы для заявки по первому подходящему правилу.
+func routeLead(l *Lead) []*notifyChannel {
+	for _, r := range notify.Routes {
+		if !r.Match.matches(l) {
+			continue
+		}
+		var out []*notifyChannel
+		for _, name := range r.Channels {
+			out = append(out, notify.Channels[name])
+		}
+		return out
+	}
+	return nil
+}
+
+// payload — содержимое сообщения для канала, фиксируется при постановке в очередь.
+func (c *notifyChannel) payload(l *Lead) string {
+	if c.Type == channelWebhook {
+		b, _ := json.Marshal(map[string]interface{}{"event": "lead.created", "lead": l})
+		return string(b)
+	}
+	return leadMessage(l)
+}
+
+func (c *notifyChannel) send(m *OutboxMessage) error {
+	switch c.Type {
+	case channelTelegram:
+		var kb *telegramKeyboard
+		if telegramActionsEnabled() {
+			// Кнопки — по состоянию заявки на момент отправки (telegram_actions.go)
+			l, err := getLead(m.LeadID)
+			if err != nil {
+				return err
+			}
+			kb = leadKeyboard(l)
+		}
+		return sendTelegramMessage(c.ChatID, m.Payload, kb)
+	case channelEmail:
+		return sendEmail(c.To, leadEmailSubject(m.Payload), m.Payload)
+	case channelWebhook:
+		return sendWebhook(c.URL, c.Secret, []byte(m.Payload))
+	}
+	return errors.New("unknown channel type " + c.Type)
+}
+
+// leadEmailSubject — тема письма из текста уведомления: «Новая заявка: Имя».
+func leadEmailSubject(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if name, ok := strings.CutPrefix(line, "Имя: "); ok {
+			return "Новая заявка: " + name
+		}
+	}
+	return "Новая заявка"
+}
+
+// --- email ---
+
+func sendEmail(to []string, subject, body string) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = os.Getenv("SMTP_USER")
+	}
+	if from == "" {
+		from = "noreply@" + host
+	}
+	addr := net.JoinHostPort(host, port)
+	tlsConfig := &tls.Config{ServerName: host}
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if port == "465" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && port != "465" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		if err := c.Auth(smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\n")
+	enc := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(enc) > 76 {
+		msg.WriteString(enc[:76] + "\r\n")
+		enc = enc[76:]
+	}
+	msg.WriteString(enc + "\r\n")
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// --- webhook ---
+
+// webhookSignature — подпись тела для получателя: HMAC-SHA256(secret, timestamp + "." + body).
+func webhookSignature(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp + "."))
+	h.Write(body)
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
+func sendWebhook(target, secret string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "InfluenceLab-Webhook/1")
+	if secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Webhook-Timestamp", ts)
+		req.Header.Set("X-Webhook-Signature", webhookSignature(secret, ts, body))
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		// Адрес вебхука может содержать токен — в last_error его не пишем
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// --- email ---
+
+type smtpMail struct {
+	Auth string
+	From string
+	To   []string
+	Data []byte
+}
+
+// fakeSMTP — SMTP-сервер на 127.0.0.1 без TLS: принимает письма и
+// отклоняет получателей из reject.
+type fakeSMTP struct {
+	ln     net.Listener
+	reject string
+	mu     sync.Mutex
+	mails  []smtpMail
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	t.Setenv("SMTP_HOST", host)
+	t.Setenv("SMTP_PORT", port)
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	tp := textproto.NewConn(conn)
+	var m smtpMail
+	tp.PrintfLine("220 127.0.0.1 fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-127.0.0.1\r\n250 AUTH PLAIN")
+		case "AUTH":
+			m.Auth = strings.TrimPrefix(arg, "PLAIN ")
+			tp.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			m.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			rcpt := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			if rcpt == s.reject {
+				tp.PrintfLine("550 5.1.1 No such user")
+				continue
+			}
+			m.To = append(m.To, rcpt)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			if m.Data, err = tp.ReadDotBytes(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.mails = append(s.mails, m)
+			s.mu.Unlock()
+			m = smtpMail{}
+			tp.PrintfLine("250 OK: queued")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTP) received() []smtpMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpMail(nil), s.mails...)
+}
+
+func TestEmailChannelSendsLead(t *testing.T) {
+	srv := newFakeSMTP(t)
+	t.Setenv("SMTP_USER", "bot@example.com")
+	t.Setenv("SMTP_PASSWORD", "pw")
+	t.Setenv("SMTP_FROM", "sales@example.com")
+
+	l := &Lead{Name: "Иван Петров", Phone: "+998901234567", Description: "Нужна реклама"}
+	c := &notifyChannel{Type: channelEmail, To: []string{"a@example.com", "b@example.com"}}
+	if err := c.send(&OutboxMessage{Payload: c.payload(l)}); err != nil {
+		t.Fatal(err)
+	}
+	mails := srv.received()
+	if len(mails) != 1 {
+		t.Fatalf("received %d mail(s)", len(mails))
+	}
+	got := mails[0]
+	if auth, _ := base64.StdEncoding.DecodeString(got.Auth); string(auth) != "\x00bot@example.com\x00pw" {
+		t.Errorf("auth %q", auth)
+	}
+	if got.From != "sales@example.com" || !reflect.DeepEqual(got.To, c.To) {
+		t.Errorf("envelope from %q to %v", got.From, got.To)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(got.Data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Новая заявка: Иван Петров" {
+		t.Errorf("subject %q (%v)", subject, err)
+	}
+	if to := msg.Header.Get("To"); to != "a@example.com, b@example.com" {
+		t.Errorf("To header %q", to)
+	}
+	body, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, msg.Body))
+	if err != nil || string(body) != leadMessage(l) {
+		t.Errorf("body %q (%v)", body, err)
+	}
+}
+
+func TestEmailRejectedRecipient(t *testing.T) {
+	srv := newFakeSMTP(t)
+	srv.reject = "nobody@example.com"
+	err := sendEmail([]string{"a@example.com", "nobody@example.com"}, "Новая заявка", "text")
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Fatalf("err = %v, want 550", err)
+	}
+	if n := len(srv.received()); n != 0 {
+		t.Errorf("mail delivered despite rejected recipient (%d)", n)
+	}
+}
+
+// --- webhook ---
+
+func TestWebhookSignature(t *testing.T) {
+	const secret = "whsecret"
+	var gotBody []byte
+	var sigOK bool
+	var gotHeaders http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotHeaders = r.Header.Clone()
+		// Получатель пересчитывает подпись так, как описано в notify.go
+		ts := r.Header.Get("X-Webhook-Timestamp")
+		sigOK = hmac.Equal([]byte(r.Header.Get("X-Webhook-Signature")), []byte(webhookSignature(secret, ts, gotBody)))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	l := &Lead{ID: 7, Name: "Иван", Phone: "+998901234567", Service: "led"}
+	c := &notifyChannel{Type: channelWebhook, URL: srv.URL, Secret: secret}
+	if err := c.send(&OutboxMessage{Payload: c.payload(l)}); err != nil {
+		t.Fatal(err)
+	}
+	if !sigOK {
+		t.Errorf("signature %q does not match body", gotHeaders.Get("X-Webhook-Signature"))
+	}
+	if !strings.HasPrefix(gotHeaders.Get("X-Webhook-Signature"), "sha256=") || gotHeaders.Get("Content-Type") != "application/json" {
+		t.Errorf("headers %v", gotHeaders)
+	}
+	ts, err := strconv.ParseInt(gotHeaders.Get("X-Webhook-Timestamp"), 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)).Abs() > time.Minute {
+		t.Errorf("timestamp %q", gotHeaders.Get("X-Webhook-Timestamp"))
+	}
+	var payload struct {
+		Event string `json:"event"`
+		Lead  Lead   `json:"lead"`
+	}
+	if err := json.Unmarshal(gotBody, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "lead.created" || payload.Lead.ID != 7 || payload.Lead.Name != "Иван" {
+		t.Errorf("payload %s", gotBody)
+	}
+
+	// Подпись привязана к телу: другое тело с той же меткой не проходит
+	if webhookSignature(secret, gotHeaders.Get("X-Webhook-Timestamp"), append(gotBody, ' ')) == gotHeaders.Get("X-Webhook-Signature") {
+		t.Error("signature does not depend on body")
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	var signed bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signed = r.Header.Get("X-Webhook-Signature") != ""
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	err := sendWebhook(srv.URL, "", []byte(`{}`))
+	if err == nil || err.Error() != "webhook responded 500 Internal Server Error" {
+		t.Errorf("err = %v", err)
+	}
+	if signed {
+		t.Error("signed without a secret")
+	}
+
+	// Токен из адреса не должен попасть в текст ошибки (он пишется в last_error)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	err = sendWebhook("http://"+addr+"/hook?token=topsecret", "", []byte(`{}`))
+	if err == nil || strings.Contains(err.Error(), "topsecret") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// --- routing ---
+
+const testNotifyConfig = `{
+  "channels": {
+    "sales":  {"type": "telegram", "chat_id": "-1001"},
+    "led":    {"type": "telegram", "chat_id": "-1002"},
+    "office": {"type": "email", "to": ["sales@example.com"]},
+    "crm":    {"type": "webhook", "url": "https://crm.example.com/hook", "secret": "s"}
+  },
+  "routes": [
+    {"match": {"service": ["led"]}, "channels": ["led", "office"]},
+    {"match": {"keywords": ["блогер", "influencer"], "page": ["/contact*"]}, "channels": ["sales"]},
+    {"channels": ["sales", "crm"]}
+  ]
+}`
+
+func loadTestNotifyConfig(t *testing.T, raw string) *notifyConfig {
+	t.Helper()
+	t.Setenv("TELEGRAM_BOT_TOKEN", "123:test")
+	t.Setenv("SMTP_HOST", "127.0.0.1")
+	cfg := &notifyConfig{}
+	if err := json.Unmarshal([]byte(raw), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestRouteLead(t *testing.T) {
+	prev := notify
+	notify = loadTestNotifyConfig(t, testNotifyConfig)
+	t.Cleanup(func() { notify = prev })
+
+	for _, tc := range []struct {
+		name string
+		lead Lead
+		want []string
+	}{
+		{"service", Lead{Service: "led", Description: "блогер", Page: "/contact"}, []string{"led", "office"}},
+		{"keyword and page", Lead{Description: "Ищем блогера", Page: "/contact"}, []string{"sales"}},
+		{"keyword in name, any case", Lead{Name: "INFLUENCER agency", Page: "/contacts"}, []string{"sales"}},
+		{"page with query and host", Lead{Description: "блогер", Page: "https://example.com/contact?utm=1"}, []string{"sales"}},
+		{"keyword without page", Lead{Description: "блогер", Page: "/about"}, []string{"sales", "crm"}},
+		{"page without keyword", Lead{Description: "реклама", Page: "/contact"}, []string{"sales", "crm"}},
+		{"other service", Lead{Service: "smm"}, []string{"sales", "crm"}},
+		{"empty lead", Lead{}, []string{"sales", "crm"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, c := range routeLead(&tc.lead) {
+				got = append(got, c.Name)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("routeLead = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRouteLeadWithoutRoutes(t *testing.T) {
+	prev := notify
+	notify = &notifyConfig{Channels: map[string]*notifyChannel{}}
+	t.Cleanup(func() { notify = prev })
+	if got := routeLead(&Lead{Name: "Иван"}); got != nil {
+		t.Errorf("routeLead = %v, want none", got)
+	}
+}
+
+func TestNotifyMatch(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		match *notifyMatch
+		lead  Lead
+		want  bool
+	}{
+		{"nil matches all", nil, Lead{}, true},
+		{"empty matches all", &notifyMatch{}, Lead{Service: "led"}, true},
+		{"service any of", &notifyMatch{Service: []string{"smm", "led"}}, Lead{Service: "led"}, true},
+		{"service mismatch", &notifyMatch{Service: []string{"smm"}}, Lead{Service: "led"}, false},
+		{"service required", &notifyMatch{Service: []string{"smm"}}, Lead{}, false},
+		{"page pattern", &notifyMatch{Page: []string{"/uz/*"}}, Lead{Page: "/uz/services"}, true},
+		{"page pattern is per segment", &notifyMatch{Page: []string{"/uz/*"}}, Lead{Page: "/uz/services/led"}, false},
+		{"page ignores query", &notifyMatch{Page: []string{"/contact"}}, Lead{Page: "/contact?from=ad"}, true},
+		{"keyword in description", &notifyMatch{Keywords: []string{"LED"}}, Lead{Description: "экран led на фасад"}, true},
+		{"keyword absent", &notifyMatch{Keywords: []string{"led"}}, Lead{Name: "Иван", Description: "реклама"}, false},
+		{"all conditions", &notifyMatch{Service: []string{"led"}, Keywords: []string{"фасад"}}, Lead{Service: "led", Description: "реклама"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.match.matches(&tc.lead); got != tc.want {
+				t.Errorf("matches = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNotifyConfigValidate(t *testing.T) {
+	t.Setenv("TELEGRAM_BOT_TOKEN", "123:test")
+	t.Setenv("SMTP_HOST", "")
+	for _, tc := range []struct {
+		name, raw, want string
+	}{
+		{"unknown channel", `{"channels": {"a": {"type": "telegram", "chat_id": "1"}}, "routes": [{"channels": ["b"]}]}`, `unknown channel "b"`},
+		{"route without channels", `{"channels": {}, "routes": [{"match": {"service": ["led"]}}]}`, "no channels"},
+		{"bad page pattern", `{"channels": {"a": {"type": "telegram", "chat_id": "1"}}, "routes": [{"match": {"page": ["[/"]}, "channels": ["a"]}]}`, "bad page pattern"},
+		{"email without smtp", `{"channels": {"a": {"type": "email", "to": ["x@example.com"]}}}`, "SMTP_HOST is not set"},
+		{"webhook url", `{"channels": {"a": {"type": "webhook", "url": "ftp://example.com"}}}`, "invalid url"},
+		{"unknown type", `{"channels": {"a": {"type": "sms"}}}`, "unknown type"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &notifyConfig{}
+			if err := json.Unmarshal([]byte(tc.raw), cfg); err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("validate = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
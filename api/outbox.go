@@ -0,0 +1,328 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- OUTBOX ---
+// Уведомления о заявках доставляются через таблицу outbox: handleForm в одной
+// транзакции сохраняет заявку и ставит сообщение в очередь, а фоновый воркер
+// отправляет его с повторами:
+//
+//	неудачная попытка n -> следующая через OUTBOX_BACKOFF (30s) * 2^(n-1), но не реже раза в час
+//	OUTBOX_MAX_ATTEMPTS (8) неудач подряд -> dead, ждёт ручного повтора
+//
+//	GET  /api/outbox?status=dead&limit=50&offset=0
+//	POST /api/outbox/{id}/retry   поставить сообщение в очередь заново
+//	POST /api/outbox/retry        то же для всех dead
+//
+// Каналы и маршрутизация — notify.go; на заявку по сообщению на канал. Сводный
+// статус доставки заявки — в leads.telegram_status: sent, когда доставлены все
+// сообщения, failed, если какое-то стало dead.
+
+const (
+	outboxPending = "pending"
+	outboxSent    = "sent"
+	outboxDead    = "dead"
+
+	outboxMaxBackoff = time.Hour
+	outboxBatch      = 20
+)
+
+type OutboxMessage struct {
+	ID            int64  `json:"id"`
+	Channel       string `json:"channel"`
+	LeadID        int64  `json:"lead_id"`
+	Payload       string `json:"payload"`
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	NextAttemptAt int64  `json:"next_attempt_at"`
+	LastError     string `json:"last_error,omitempty"`
+	CreatedAt     int64  `json:"created_at"`
+	SentAt        int64  `json:"sent_at,omitempty"`
+}
+
+var outboxWake = make(chan struct{}, 1)
+
+// wakeOutbox будит воркер, не дожидаясь очередного опроса.
+func wakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid %s %q, using %s", name, v, def)
+	}
+	return def
+}
+
+func outboxMaxAttempts() int {
+	if n, err := strconv.Atoi(os.Getenv("OUTBOX_MAX_ATTEMPTS")); err == nil && n > 0 {
+		return n
+	}
+	return 8
+}
+
+// outboxBackoff — пауза после attempts неудачных попыток.
+func outboxBackoff(attempts int) time.Duration {
+	d := float64(envDuration("OUTBOX_BACKOFF", 30*time.Second)) * math.Pow(2, float64(attempts-1))
+	if d > float64(outboxMaxBackoff) {
+		return outboxMaxBackoff
+	}
+	return time.Duration(d)
+}
+
+// enqueueLeadNotifications ставит уведомления о заявке в очередь, по одному на канал.
+func enqueueLeadNotifications(q queryExecer, l *Lead, channels []*notifyChannel) error {
+	now := time.Now().Unix()
+	for _, c := range channels {
+		if _, err := q.Exec(`INSERT INTO outbox (channel, lead_id, payload, status, next_attempt_at, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)`, c.Name, l.ID, c.payload(l), outboxPending, now, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startOutbox запускает воркер; OUTBOX_POLL_INTERVAL (5s) — как часто смотреть в очередь.
+func startOutbox() {
+	poll := envDuration("OUTBOX_POLL_INTERVAL", 5*time.Second)
+	go func() {
+		t := time.NewTicker(poll)
+		defer t.Stop()
+		for {
+			processOutbox()
+			select {
+			case <-t.C:
+			case <-outboxWake:
+			}
+		}
+	}()
+}
+
+// processOutbox отправляет все сообщения, срок которых подошёл.
+func processOutbox() {
+	for {
+		msgs, err := dueOutbox(time.Now().Unix(), outboxBatch)
+		if err != nil {
+			log.Println("[outbox] DB error:", err)
+			return
+		}
+		for i := range msgs {
+			if err := deliverOutbox(&msgs[i]); err != nil {
+				log.Println("[outbox] DB error:", err)
+				return
+			}
+		}
+		if len(msgs) < outboxBatch {
+			return
+		}
+	}
+}
+
+func dueOutbox(now int64, limit int) ([]OutboxMessage, error) {
+	return scanOutbox(db.Query(outboxSelect+` WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, id LIMIT ?`, outboxPending, now, limit))
+}
+
+const outboxSelect = `SELECT id, channel, lead_id, payload, status, attempts, next_attempt_at, last_error, created_at, IFNULL(sent_at, 0) FROM outbox`
+
+func scanOutbox(rows *sql.Rows, err error) ([]OutboxMessage, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []OutboxMessage{}
+	for rows.Next() {
+		var m OutboxMessage
+		if err := rows.Scan(&m.ID, &m.Channel, &m.LeadID, &m.Payload, &m.Status, &m.Attempts,
+			&m.NextAttemptAt, &m.LastError, &m.CreatedAt, &m.SentAt); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+func sendOutbox(m *OutboxMessage) error {
+	c := notify.Channels[m.Channel]
+	if c == nil {
+		// Канал убрали из настроек после постановки в очередь
+		return errors.New("channel " + m.Channel + " is not configured")
+	}
+	return c.send(m)
+}
+
+// refreshLeadStatus пересчитывает сводный статус доставки заявки.
+func refreshLeadStatus(leadID int64) error {
+	var total, sent, dead int
+	var lastError string
+	err := db.QueryRow(`SELECT COUNT(*), IFNULL(SUM(status = ?), 0), IFNULL(SUM(status = ?), 0),
+		IFNULL((SELECT channel || ': ' || last_error FROM outbox WHERE lead_id = ? AND status != ? AND last_error != ''
+			ORDER BY id DESC LIMIT 1), '')
+		FROM outbox WHERE lead_id = ?`, outboxSent, outboxDead, leadID, outboxSent, leadID).Scan(&total, &sent, &dead, &lastError)
+	if err != nil || total == 0 {
+		return err
+	}
+	status := leadPending
+	switch {
+	case dead > 0:
+		status = leadFailed
+	case sent == total:
+		status = leadSent
+	}
+	_, err = db.Exec("UPDATE leads SET telegram_status = ?, telegram_error = ? WHERE id = ?", status, lastError, leadID)
+	return err
+}
+
+// deliverOutbox делает одну попытку и записывает результат; ошибка — только от БД.
+func deliverOutbox(m *OutboxMessage) error {
+	now := time.Now()
+	m.Attempts++
+	sendErr := sendOutbox(m)
+	if sendErr == nil {
+		if _, err := db.Exec("UPDATE outbox SET status = ?, attempts = ?, last_error = '', sent_at = ? WHERE id = ?",
+			outboxSent, m.Attempts, now.Unix(), m.ID); err != nil {
+			return err
+		}
+		return refreshLeadStatus(m.LeadID)
+	}
+
+	status := outboxPending
+	next := now.Add(outboxBackoff(m.Attempts))
+	var te *telegramError
+	if errors.As(sendErr, &te) && te.RetryAfter > 0 && now.Add(te.RetryAfter).After(next) {
+		next = now.Add(te.RetryAfter)
+	}
+	if m.Attempts >= outboxMaxAttempts() {
+		status = outboxDead
+		log.Printf("[outbox] %s message %d (lead %d) is dead after %d attempts: %v", m.Channel, m.ID, m.LeadID, m.Attempts, sendErr)
+	} else {
+		log.Printf("[outbox] %s message %d (lead %d) attempt %d failed, retry at %s: %v",
+			m.Channel, m.ID, m.LeadID, m.Attempts, next.Format(time.RFC3339), sendErr)
+	}
+	if _, err := db.Exec("UPDATE outbox SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?",
+		status, m.Attempts, next.Unix(), sendErr.Error(), m.ID); err != nil {
+		return err
+	}
+	return refreshLeadStatus(m.LeadID)
+}
+
+// retryOutbox возвращает в очередь неотправленные сообщения (id == 0 — все dead).
+func retryOutbox(id int64) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	where, args := "status = ?", []interface{}{outboxDead}
+	if id != 0 {
+		where, args = "id = ? AND status != ?", []interface{}{id, outboxSent}
+	}
+	if _, err := tx.Exec(`UPDATE leads SET telegram_status = ?
+		WHERE id IN (SELECT lead_id FROM outbox WHERE `+where+`)`, append([]interface{}{leadPending}, args...)...); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`UPDATE outbox SET status = ?, attempts = 0, next_attempt_at = ?
+		WHERE `+where, append([]interface{}{outboxPending, time.Now().Unix()}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		wakeOutbox()
+	}
+	return n, nil
+}
+
+// GET /api/outbox
+func handleOutbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	limit, offset := 50, 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	where, args := "1 = 1", []interface{}{}
+	switch s := q.Get("status"); s {
+	case "":
+	case outboxPending, outboxSent, outboxDead:
+		where, args = "status = ?", append(args, s)
+	default:
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM outbox WHERE "+where, args...).Scan(&total); err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	list, err := scanOutbox(db.Query(outboxSelect+" WHERE "+where+" ORDER BY id DESC LIMIT ? OFFSET ?", append(args, limit, offset)...))
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": list, "total": total, "limit": limit, "offset": offset})
+}
+
+// POST /api/outbox/retry, POST /api/outbox/{id}/retry
+func handleOutboxRetry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/api/outbox/")
+	var id int64
+	if rest != "retry" {
+		idStr, ok := strings.CutSuffix(rest, "/retry")
+		n, err := strconv.ParseInt(idStr, 10, 64)
+		if !ok || err != nil || n <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+		id = n
+	}
+	n, err := retryOutbox(id)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if id != 0 && n == 0 {
+		http.Error(w, "Not found or already sent", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "retried": n})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupOutbox — БД, фейковый Bot API и один канал telegram из env; заявка
+// сохраняется через saveLead и ставит в очередь одно сообщение.
+func setupOutbox(t *testing.T) (*fakeBotAPI, *Lead, int64) {
+	t.Helper()
+	setupTestDB(t)
+	bot := newFakeBotAPI(t)
+	t.Setenv("TELEGRAM_CHAT_ID", "-100")
+	t.Setenv("OUTBOX_BACKOFF", "30s")
+	prev := notify
+	notify = notifyConfigFromEnv()
+	if err := notify.validate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { notify = prev })
+
+	l := &Lead{Name: "Иван", Phone: "+998901234567", Description: "Нужна реклама", CreatedAt: time.Now().Unix()}
+	if err := saveLead(l); err != nil {
+		t.Fatal(err)
+	}
+	var id int64
+	if err := db.QueryRow("SELECT id FROM outbox WHERE lead_id = ?", l.ID).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return bot, l, id
+}
+
+func getOutbox(t *testing.T, id int64) OutboxMessage {
+	t.Helper()
+	list, err := scanOutbox(db.Query(outboxSelect+" WHERE id = ?", id))
+	if err != nil || len(list) != 1 {
+		t.Fatalf("outbox %d: %v (%d rows)", id, err, len(list))
+	}
+	return list[0]
+}
+
+func leadDeliveryStatus(t *testing.T, id int64) string {
+	t.Helper()
+	l, err := getLead(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l.TelegramStatus
+}
+
+// makeDue переносит следующую попытку на «сейчас», чтобы не ждать паузу.
+func makeDue(t *testing.T, id int64) {
+	t.Helper()
+	if _, err := db.Exec("UPDATE outbox SET next_attempt_at = 0 WHERE id = ?", id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertNextAttempt проверяет, что следующая попытка назначена через want (±2s).
+func assertNextAttempt(t *testing.T, m OutboxMessage, start time.Time, want time.Duration) {
+	t.Helper()
+	got := time.Unix(m.NextAttemptAt, 0).Sub(start)
+	if got < want-2*time.Second || got > want+2*time.Second {
+		t.Errorf("attempt %d: next attempt in %s, want %s", m.Attempts, got, want)
+	}
+}
+
+func telegramFailure(status int) func(string) (int, string) {
+	return func(string) (int, string) {
+		return status, fmt.Sprintf(`{"ok":false,"error_code":%d,"description":"%s"}`, status, http.StatusText(status))
+	}
+}
+
+func TestOutboxSendsToTelegram(t *testing.T) {
+	bot, l, id := setupOutbox(t)
+	processOutbox()
+
+	sends := bot.callsTo("sendMessage")
+	if len(sends) != 1 {
+		t.Fatalf("sendMessage called %d time(s)", len(sends))
+	}
+	if p := sends[0].Params; p.Get("chat_id") != "-100" || !strings.Contains(p.Get("text"), "Имя: Иван") {
+		t.Errorf("sent %v", p)
+	}
+	m := getOutbox(t, id)
+	if m.Status != outboxSent || m.Attempts != 1 || m.SentAt == 0 || m.LastError != "" {
+		t.Errorf("message %+v", m)
+	}
+	if s := leadDeliveryStatus(t, l.ID); s != leadSent {
+		t.Errorf("lead delivery status %q", s)
+	}
+	processOutbox()
+	if n := len(bot.callsTo("sendMessage")); n != 1 {
+		t.Errorf("sent message resent (%d sends)", n)
+	}
+}
+
+func TestOutboxHonoursRetryAfter(t *testing.T) {
+	bot, l, id := setupOutbox(t)
+	bot.setReply(func(string) (int, string) {
+		return http.StatusTooManyRequests, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 120","parameters":{"retry_after":120}}`
+	})
+	start := time.Now()
+	processOutbox()
+
+	m := getOutbox(t, id)
+	if m.Status != outboxPending || m.Attempts != 1 || !strings.Contains(m.LastError, "429") {
+		t.Errorf("message %+v", m)
+	}
+	// retry_after (120s) больше первой паузы (30s) — ждём сколько просит Telegram
+	assertNextAttempt(t, m, start, 120*time.Second)
+	if s := leadDeliveryStatus(t, l.ID); s != leadPending {
+		t.Errorf("lead delivery status %q", s)
+	}
+	processOutbox()
+	if n := len(bot.callsTo("sendMessage")); n != 1 {
+		t.Errorf("retried before retry_after (%d sends)", n)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	t.Setenv("OUTBOX_BACKOFF", "30s")
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour}, // 64m — упирается в потолок
+		{20, time.Hour},
+	} {
+		if got := outboxBackoff(tc.attempts); got != tc.want {
+			t.Errorf("outboxBackoff(%d) = %s, want %s", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+func TestOutboxDeadAfterMaxAttemptsAndRetry(t *testing.T) {
+	bot, l, id := setupOutbox(t)
+	t.Setenv("OUTBOX_MAX_ATTEMPTS", "3")
+	bot.setReply(telegramFailure(http.StatusBadGateway))
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		makeDue(t, id)
+		start := time.Now()
+		processOutbox()
+		m := getOutbox(t, id)
+		if m.Attempts != attempt {
+			t.Fatalf("attempts %d, want %d", m.Attempts, attempt)
+		}
+		if attempt < 3 {
+			if m.Status != outboxPending {
+				t.Fatalf("attempt %d: status %q", attempt, m.Status)
+			}
+			// 30s, 60s — пауза удваивается
+			assertNextAttempt(t, m, start, outboxBackoff(attempt))
+		} else if m.Status != outboxDead || !strings.Contains(m.LastError, "502") {
+			t.Fatalf("after max attempts: %+v", m)
+		}
+	}
+	if s := leadDeliveryStatus(t, l.ID); s != leadFailed {
+		t.Errorf("lead delivery status %q, want failed", s)
+	}
+	makeDue(t, id)
+	processOutbox()
+	if n := len(bot.callsTo("sendMessage")); n != 3 {
+		t.Fatalf("dead message was sent again (%d sends)", n)
+	}
+
+	// Ручной повтор возвращает сообщение в очередь с нуля
+	w := httptest.NewRecorder()
+	handleOutboxRetry(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/outbox/%d/retry", id), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("retry: status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Status  string `json:"status"`
+		Retried int    `json:"retried"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Status != "ok" || resp.Retried != 1 {
+		t.Errorf("retry response %s", w.Body)
+	}
+	if m := getOutbox(t, id); m.Status != outboxPending || m.Attempts != 0 {
+		t.Errorf("after retry: %+v", m)
+	}
+	if s := leadDeliveryStatus(t, l.ID); s != leadPending {
+		t.Errorf("lead delivery status after retry %q", s)
+	}
+
+	bot.setReply(nil)
+	processOutbox()
+	if m := getOutbox(t, id); m.Status != outboxSent || m.Attempts != 1 {
+		t.Errorf("after retry delivery: %+v", m)
+	}
+	if s := leadDeliveryStatus(t, l.ID); s != leadSent {
+		t.Errorf("lead delivery status %q, want sent", s)
+	}
+
+	// Доставленное повторить нельзя
+	w = httptest.NewRecorder()
+	handleOutboxRetry(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/outbox/%d/retry", id), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("retry of a sent message: status %d", w.Code)
+	}
+}
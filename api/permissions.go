@@ -0,0 +1,365 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// --- ROLES & PERMISSIONS ---
+// Роль хранится в users.role, набор прав у роли фиксирован в коде.
+
+type Permission string
+
+const (
+	permContentCreate    Permission = "content.create"
+	permContentEdit      Permission = "content.edit"
+	permContentDelete    Permission = "content.delete"
+	permContentTranslate Permission = "content.translate" // только поля *_uz / *_en
+	permTranslateAPI     Permission = "translate.use"
+	permLeadsRead        Permission = "leads.read"
+	permLeadsManage      Permission = "leads.manage"  // этапы, ответственный, заметки
+	permMediaManage      Permission = "media.manage"  // GC загрузок
+	permOutboxManage     Permission = "outbox.manage" // очередь уведомлений, повтор доставки
+	permUsersManage      Permission = "users.manage"
+)
+
+const (
+	roleAdmin      = "admin"
+	roleEditor     = "editor"
+	roleTranslator = "translator"
+	roleSales      = "sales"
+)
+
+var rolePermissions = map[string][]Permission{
+	roleAdmin: {
+		permContentCreate, permContentEdit, permContentDelete, permContentTranslate,
+		permTranslateAPI, permLeadsRead, permLeadsManage, permMediaManage, permOutboxManage, permUsersManage,
+	},
+	roleEditor: {
+		permContentCreate, permContentEdit, permContentDelete, permContentTranslate, permTranslateAPI,
+	},
+	roleTranslator: {
+		permContentTranslate, permTranslateAPI,
+	},
+	roleSales: {
+		permLeadsRead, permLeadsManage,
+	},
+}
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func hasPermission(u *User, p Permission) bool {
+	if u == nil {
+		return false
+	}
+	for _, have := range rolePermissions[u.Role] {
+		if have == p {
+			return true
+		}
+	}
+	return false
+}
+
+func userPermissions(u *User) []Permission {
+	if u == nil {
+		return nil
+	}
+	return rolePermissions[u.Role]
+}
+
+// requirePermission пишет 403 и возвращает false, если у текущего пользователя нет права.
+func requirePermission(w http.ResponseWriter, r *http.Request, p Permission) bool {
+	if hasPermission(currentUser(r), p) {
+		return true
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// withPermission — то же самое в виде middleware (после withAuth).
+func withPermission(p Permission, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requirePermission(w, r, p) {
+			return
+		}
+		h(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// listFieldForFormKey сопоставляет ключ формы (links, link1..linkN) списочному полю схемы.
+func listFieldForFormKey(ct *ContentType, key string) string {
+	for _, f := range ct.Fields {
+		if f.Kind != FieldList {
+			continue
+		}
+		if key == f.Name {
+			return f.Name
+		}
+		rest := strings.TrimPrefix(key, strings.TrimSuffix(f.Name, "s"))
+		if rest != key && rest != "" && strings.Trim(rest, "0123456789") == "" {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+// handleTranslationUpdate обрабатывает правку записи пользователем, у которого есть
+// только content.translate: сохраняются лишь *_uz/*_en поля, а попытка изменить
+// любое другое поле (title, description, картинки, ссылки...) отклоняется с 403.
+// Неизменённые значения остальных полей допускаются, т.к. админка шлёт форму целиком.
+// У картинок переводчику доступны alt_uz/alt_en и caption_uz/caption_en.
+// В JSON значения колонок — строки или массивы строк; null, числа и объекты
+// отклоняются с 400 (кроме служебных id, created_at, updated_at и img).
+func handleTranslationUpdate(w http.ResponseWriter, r *http.Request, ct *ContentType, id string) {
+	scalars := map[string]string{}
+	lists := map[string][]string{}
+	var imageMeta map[string]*ImageMeta
+	var forbidden, badType []string
+
+	if isMultipart(r) {
+		if err := parseUploadForm(r); err != nil {
+			writeRequestError(w, err)
+			return
+		}
+		for name, files := range r.MultipartForm.File {
+			if len(files) > 0 {
+				forbidden = append(forbidden, name)
+			}
+		}
+		for name, vals := range r.MultipartForm.Value {
+			switch {
+			case name == "imgOld":
+				// производное от imagesOld
+			case name == "imagesOld":
+				var images []string
+				if strings.TrimSpace(vals[0]) != "" {
+					var err error
+					if images, imageMeta, err = parseImagesJSON([]byte(vals[0])); err != nil {
+						writeRequestError(w, imagesError(err, "Invalid imagesOld"))
+						return
+					}
+				}
+				lists["images"] = images
+			case listFieldForFormKey(ct, name) != "":
+				f, _ := ct.field(listFieldForFormKey(ct, name))
+				lists[f.Name] = parseListFromForm(r, f.Name, f.MaxItems)
+			case len(vals) > 0:
+				scalars[name] = vals[0]
+			}
+		}
+	} else {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		for name, v := range body {
+			switch val := v.(type) {
+			case string:
+				scalars[name] = val
+			case []interface{}:
+				if name == "images" {
+					raw, _ := json.Marshal(val)
+					images, meta, err := parseImagesJSON(raw)
+					if err != nil {
+						writeRequestError(w, imagesError(err, "Invalid JSON: images"))
+						return
+					}
+					lists[name], imageMeta = images, meta
+					continue
+				}
+				arr := []string{}
+				for _, item := range val {
+					s, ok := item.(string)
+					if !ok {
+						badType = append(badType, name)
+						break
+					}
+					arr = append(arr, s)
+				}
+				lists[name] = arr
+			default:
+				badType = append(badType, name)
+			}
+		}
+		delete(scalars, "img")
+		delete(scalars, "id")
+	}
+
+	current, err := loadRowStrings(ct.Table, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	// Неизвестные ключи по-прежнему игнорируются; неверный тип у колонки — ошибка,
+	// а не молча пропущенное поле
+	var invalid []string
+	for _, name := range badType {
+		if _, known := current[name]; known && !serverManagedColumn(name) {
+			invalid = append(invalid, name)
+		}
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "fields must be strings or arrays of strings",
+			"fields": invalid,
+		})
+		return
+	}
+
+	var setCols []string
+	var args []interface{}
+	for name, val := range scalars {
+		cur, known := current[name]
+		if !known {
+			continue
+		}
+		if f, ok := ct.field(name); ok && f.Translatable {
+			setCols = append(setCols, name+"=?")
+			args = append(args, val)
+			continue
+		}
+		if val != cur {
+			forbidden = append(forbidden, name)
+		}
+	}
+	for name, val := range lists {
+		cur, known := current[name]
+		if !known {
+			continue
+		}
+		var curList []string
+		if cur != "" {
+			if name == "images" {
+				curList, _, _ = parseImagesJSON([]byte(cur))
+			} else {
+				_ = json.Unmarshal([]byte(cur), &curList)
+			}
+		}
+		if !sameStrings(val, curList) {
+			forbidden = append(forbidden, name)
+		}
+	}
+	var imagesItem *Item
+	if len(imageMeta) > 0 && len(forbidden) == 0 {
+		it, err := ct.getItem(id)
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		changed, ok := it.translateImageMeta(imageMeta)
+		if !ok {
+			forbidden = append(forbidden, "images")
+		} else if changed {
+			imagesItem = it
+		}
+	}
+	if len(forbidden) > 0 {
+		sort.Strings(forbidden)
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{
+			"error":  "only translation fields (*_uz, *_en) may be changed",
+			"fields": forbidden,
+		})
+		return
+	}
+	if imagesItem != nil {
+		if err := ct.updateImages(imagesItem); err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if len(setCols) == 0 {
+			contentChanged(ct)
+		}
+	}
+	if len(setCols) > 0 {
+		args = append(args, id)
+		if _, err := db.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE id=?", ct.Table, strings.Join(setCols, ", ")), args...); err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		// Новый title_uz/title_en — новый slug этого языка
+		if it, err := ct.getItem(id); err == nil {
+			for _, f := range ct.Fields {
+				if f.SlugOf != "" {
+					it.Values[f.Name] = ""
+				}
+			}
+			if err := ct.syncSlugs(db, it); err != nil {
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+		}
+		contentChanged(ct)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// serverManagedColumn — колонки, которые клиент получает в ответе и может
+// прислать обратно как есть; при сохранении они не учитываются.
+func serverManagedColumn(name string) bool {
+	return name == "id" || name == "img" || name == "created_at" || name == "updated_at"
+}
+
+// loadRowStrings возвращает строку таблицы как map колонка -> значение.
+func loadRowStrings(table string, id string) (map[string]string, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s WHERE id=?", table), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
+	vals := make([]sql.NullString, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(cols))
+	for i, c := range cols {
+		out[c] = vals[i].String
+	}
+	return out, nil
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTranslatorFieldGate(t *testing.T) {
+	setupTestDB(t)
+	ct := contentTypeByName("blog")
+	it := ct.newItem()
+	it.Values["title"] = "Привет"
+	it.Values["description"] = "Описание"
+	it.Values["links"] = []string{"https://a.example"}
+	if err := ct.insertItem(it); err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(it.ID)
+	translator := &User{ID: 1, Login: "tr", Role: roleTranslator}
+
+	for _, tc := range []struct {
+		name   string
+		body   string
+		want   int
+		fields []string
+	}{
+		{"translation", `{"title_uz":"Salom","title_en":"Hello"}`, http.StatusOK, nil},
+		{"unchanged source", `{"title":"Привет","links":["https://a.example"],"description_en":"About"}`, http.StatusOK, nil},
+		{"changed source", `{"title":"Пока","title_en":"Bye"}`, http.StatusForbidden, []string{"title"}},
+		{"changed list", `{"links":["https://b.example"]}`, http.StatusForbidden, []string{"links"}},
+		// Служебные колонки и неизвестные ключи молча пропускаются
+		{"server-managed", `{"id":5,"created_at":123,"updated_at":null,"img":null,"title_uz":"Salom"}`, http.StatusOK, nil},
+		{"unknown keys", `{"foo":null,"bar":{"x":1},"title_uz":"Salom"}`, http.StatusOK, nil},
+		{"null", `{"title":null}`, http.StatusBadRequest, []string{"title"}},
+		{"null translation", `{"title_uz":null}`, http.StatusBadRequest, []string{"title_uz"}},
+		{"number", `{"title_en":42}`, http.StatusBadRequest, []string{"title_en"}},
+		{"bool", `{"description":false}`, http.StatusBadRequest, []string{"description"}},
+		{"object", `{"description_uz":{"text":"x"}}`, http.StatusBadRequest, []string{"description_uz"}},
+		{"non-string element", `{"links":["https://a.example",1]}`, http.StatusBadRequest, []string{"links"}},
+		{"several", `{"title":1,"links":[null],"title_uz":"Salom"}`, http.StatusBadRequest, []string{"links", "title"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/api/blog/"+id, strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", "application/json")
+			r = r.WithContext(context.WithValue(r.Context(), userCtxKey, translator))
+			w := httptest.NewRecorder()
+			ct.handleItem(w, r)
+			if w.Code != tc.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tc.want, w.Body)
+			}
+			if tc.fields == nil {
+				return
+			}
+			var resp struct{ Fields []string }
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("response %q: %v", w.Body, err)
+			}
+			sort.Strings(resp.Fields)
+			if strings.Join(resp.Fields, ",") != strings.Join(tc.fields, ",") {
+				t.Errorf("fields %v, want %v", resp.Fields, tc.fields)
+			}
+		})
+	}
+
+	// Отклонённые запросы ничего не записали, разрешённые — записали
+	got, err := ct.getItem(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"title": "Привет", "title_uz": "Salom", "title_en": "Hello",
+		"description": "Описание", "description_uz": "", "description_en": "About",
+	} {
+		if got.Text(name) != want {
+			t.Errorf("%s = %q, want %q", name, got.Text(name), want)
+		}
+	}
+	if l := got.List("links"); len(l) != 1 || l[0] != "https://a.example" {
+		t.Errorf("links = %v", l)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// --- SEARCH ---
+// Полнотекстовый поиск по title/description на ru/uz/en через SQLite FTS5.
+// FTS5 в go-sqlite3 включается тегом сборки: go build -tags sqlite_fts5.
+// Сборка без тега тоже работает: индекс не создаётся (migrations.go), а поиск
+// идёт подстрокой через LIKE — без ранжирования, и регистр кириллицы LIKE в
+// SQLite не сворачивает.
+//
+// Индекс search_index содержит по строке на (таблица, запись, язык) и
+// поддерживается триггерами, поэтому синхронизация не зависит от того,
+// какой обработчик меняет таблицу.
+
+// Замены, которые применяются и к индексируемому тексту, и к запросу.
+// Узбекская латиница пишет oʻ/gʻ разными апострофами; U+02BB — буква (Lm)
+// для unicode61, поэтому все варианты приводим к нему. Ё unicode61 не
+// сворачивает, а в русских текстах её пишут через раз.
+var searchReplacements = [][2]string{
+	{"'", "ʻ"}, {"’", "ʻ"}, {"‘", "ʻ"}, {"ʼ", "ʻ"}, {"`", "ʻ"},
+	{"ё", "е"}, {"Ё", "Е"},
+}
+
+var searchLangColumns = []struct {
+	Lang, Title, Body string
+}{
+	{"ru", "title", "description"},
+	{"uz", "title_uz", "description_uz"},
+	{"en", "title_en", "description_en"},
+}
+
+// normalizeSQL оборачивает выражение в replace() для searchReplacements.
+func normalizeSQL(expr string) string {
+	out := "IFNULL(" + expr + ",'')"
+	for _, r := range searchReplacements {
+		out = fmt.Sprintf("replace(%s, '%s', '%s')", out, strings.ReplaceAll(r[0], "'", "''"), r[1])
+	}
+	return out
+}
+
+func normalizeSearchText(s string) string {
+	for _, r := range searchReplacements {
+		s = strings.ReplaceAll(s, r[0], r[1])
+	}
+	return s
+}
+
+// searchIndexSQL создаёт таблицу индекса (один раз) и триггеры + начальное
+// заполнение для перечисленных таблиц.
+func searchIndexSQL(create bool, tables ...string) string {
+	var b strings.Builder
+	if create {
+		b.WriteString(`CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+	type UNINDEXED,
+	item_id UNINDEXED,
+	lang UNINDEXED,
+	title,
+	body,
+	tokenize = "unicode61 remove_diacritics 2"
+);
+`)
+	}
+	for _, t := range tables {
+		insert := func(prefix string) string {
+			var s strings.Builder
+			for _, l := range searchLangColumns {
+				fmt.Fprintf(&s, "\tINSERT INTO search_index (type, item_id, lang, title, body) VALUES ('%s', %s.id, '%s', %s, %s);\n",
+					t, prefix, l.Lang, normalizeSQL(prefix+"."+l.Title), normalizeSQL(prefix+"."+l.Body))
+			}
+			return s.String()
+		}
+		del := fmt.Sprintf("\tDELETE FROM search_index WHERE type = '%s' AND item_id = old.id;\n", t)
+		fmt.Fprintf(&b, "CREATE TRIGGER IF NOT EXISTS %s_search_ai AFTER INSERT ON %s BEGIN\n%sEND;\n", t, t, insert("new"))
+		fmt.Fprintf(&b, "CREATE TRIGGER IF NOT EXISTS %s_search_au AFTER UPDATE ON %s BEGIN\n%s%sEND;\n", t, t, del, insert("new"))
+		fmt.Fprintf(&b, "CREATE TRIGGER IF NOT EXISTS %s_search_ad AFTER DELETE ON %s BEGIN\n%sEND;\n", t, t, del)
+		for _, l := range searchLangColumns {
+			fmt.Fprintf(&b, "INSERT INTO search_index (type, item_id, lang, title, body) SELECT '%s', id, '%s', %s, %s FROM %s;\n",
+				t, l.Lang, normalizeSQL(l.Title), normalizeSQL(l.Body), t)
+		}
+	}
+	return b.String()
+}
+
+var (
+	fts5Once sync.Once
+	fts5OK   bool
+)
+
+// fts5Available — собран ли SQLite с модулем fts5 (тег sqlite_fts5).
+func fts5Available() bool {
+	fts5Once.Do(func() {
+		var used int
+		fts5OK = db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&used) == nil && used == 1
+	})
+	return fts5OK
+}
+
+// searchIndexReady — есть ли индекс FTS5, которым можно пользоваться.
+func searchIndexReady() bool {
+	if !fts5Available() {
+		return false
+	}
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'search_index'").Scan(&n)
+	return err == nil && n > 0
+}
+
+// searchWords — слова запроса (не больше 10) после нормализации.
+func searchWords(q string) []string {
+	words := strings.FieldsFunc(normalizeSearchText(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > 10 {
+		words = words[:10]
+	}
+	return words
+}
+
+// ftsQuery превращает пользовательский ввод в безопасный запрос FTS5:
+// каждое слово в кавычках с префиксным поиском, слова через AND.
+func ftsQuery(q string) string {
+	var terms []string
+	for _, w := range searchWords(q) {
+		terms = append(terms, `"`+w+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+type SearchResult struct {
+	Type    string  `json:"type"`
+	ID      int     `json:"id"`
+	Lang    string  `json:"lang"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Img     string  `json:"img"`
+	URL     string  `json:"url"`
+	Rank    float64 `json:"rank"`
+}
+
+// Маркеры подсветки заменяются на <mark> уже после html-экранирования текста
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+func highlightHTML(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, markStart, "<mark>")
+	return strings.ReplaceAll(s, markEnd, "</mark>")
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	match := ftsQuery(q.Get("q"))
+	if match == "" {
+		http.Error(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+	where := "search_index MATCH ?"
+	args := []interface{}{match}
+	var onlyType *ContentType
+	if t := q.Get("type"); t != "" {
+		onlyType = contentTypeByName(t)
+		if onlyType == nil {
+			http.Error(w, "Unknown type", http.StatusBadRequest)
+			return
+		}
+		where += " AND type = ?"
+		args = append(args, onlyType.Table)
+	}
+	lang := q.Get("lang")
+	if lang != "" {
+		if lang != "ru" && lang != "uz" && lang != "en" {
+			http.Error(w, "Lang must be 'ru', 'uz' or 'en'", http.StatusBadRequest)
+			return
+		}
+		where += " AND lang = ?"
+		args = append(args, lang)
+	}
+	limit := 20
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 && n <= maxPageSize {
+		limit = n
+	}
+	if !searchIndexReady() {
+		results, err := searchLike(searchWords(q.Get("q")), onlyType, lang, limit)
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"query":   q.Get("q"),
+			"results": results,
+		})
+		return
+	}
+	// Без lang одна запись может совпасть на нескольких языках — берём лучший
+	args = append(args, limit*3)
+	rows, err := db.Query(`SELECT type, item_id, lang,
+			highlight(search_index, 3, '`+markStart+`', '`+markEnd+`'),
+			snippet(search_index, 4, '`+markStart+`', '`+markEnd+`', '…', 24),
+			bm25(search_index, 0, 0, 0, 10.0, 1.0) AS rank
+		FROM search_index WHERE `+where+` ORDER BY rank LIMIT ?`, args...)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	results := []SearchResult{}
+	seen := map[string]bool{}
+	for rows.Next() {
+		var res SearchResult
+		var table string
+		if err := rows.Scan(&table, &res.ID, &res.Lang, &res.Title, &res.Snippet, &res.Rank); err != nil {
+			continue
+		}
+		key := fmt.Sprintf("%s/%d", table, res.ID)
+		if seen[key] || len(results) >= limit {
+			continue
+		}
+		seen[key] = true
+		ct := contentTypeByTable(table)
+		if ct == nil {
+			continue
+		}
+		res.Type = ct.Name
+		res.Title = highlightHTML(res.Title)
+		res.Snippet = highlightHTML(res.Snippet)
+		res.URL = ct.pageURL(res.ID, res.Lang)
+		_ = db.QueryRow("SELECT IFNULL(img,'') FROM "+ct.Table+" WHERE id = ?", res.ID).Scan(&res.Img)
+		results = append(results, res)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"query":   q.Get("q"),
+		"results": results,
+	})
+}
+
+// searchLike — поиск без FTS5: все слова должны встретиться подстрокой в
+// заголовке или тексте на одном языке. Новые записи выше.
+func searchLike(words []string, onlyType *ContentType, lang string, limit int) ([]SearchResult, error) {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	mark := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+	esc := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	results := []SearchResult{}
+	for _, ct := range contentTypes {
+		if onlyType != nil && ct != onlyType {
+			continue
+		}
+		seen := map[int]bool{}
+		for _, l := range searchLangColumns {
+			if lang != "" && l.Lang != lang {
+				continue
+			}
+			text := normalizeSQL(l.Title) + " || ' ' || " + normalizeSQL(l.Body)
+			where := "1 = 1"
+			var args []interface{}
+			for _, w := range words {
+				where += " AND " + text + ` LIKE ? ESCAPE '\'`
+				args = append(args, "%"+esc.Replace(w)+"%")
+			}
+			rows, err := db.Query(`SELECT id, IFNULL(`+l.Title+`, ''), IFNULL(`+l.Body+`, ''), IFNULL(img, '')
+				FROM `+ct.Table+` WHERE `+where+` ORDER BY id DESC LIMIT ?`, append(args, limit)...)
+			if err != nil {
+				return nil, err
+			}
+			for rows.Next() {
+				var res SearchResult
+				var body string
+				if err := rows.Scan(&res.ID, &res.Title, &body, &res.Img); err != nil {
+					rows.Close()
+					return nil, err
+				}
+				if seen[res.ID] {
+					continue
+				}
+				seen[res.ID] = true
+				res.Type, res.Lang = ct.Name, l.Lang
+				res.Title = highlightHTML(mark.ReplaceAllString(normalizeSearchText(res.Title), markStart+"$0"+markEnd))
+				res.Snippet = highlightHTML(mark.ReplaceAllString(likeSnippet(normalizeSearchText(body), mark), markStart+"$0"+markEnd))
+				res.URL = ct.pageURL(res.ID, res.Lang)
+				results = append(results, res)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// likeSnippet — кусок текста вокруг первого совпадения, как snippet() у FTS5.
+func likeSnippet(body string, mark *regexp.Regexp) string {
+	runes := []rune(body)
+	start := 0
+	if loc := mark.FindStringIndex(body); loc != nil {
+		start = len([]rune(body[:loc[0]])) - 60
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + 200
+	if end > len(runes) {
+		end = len(runes)
+	}
+	snippet := string(runes[start:end])
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
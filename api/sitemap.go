@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// --- SITEMAP ---
+// /sitemap.xml собирается из БД: статические страницы + все записи blog,
+// projects и led с hreflang-альтернативами, lastmod из updated_at и
+// image:image из images. Готовый XML кэшируется до изменения контента
+// (contentChanged).
+
+type sitemapPage struct {
+	Path       string
+	ChangeFreq string
+	Priority   string
+	Content    string // тип, чьи записи влияют на lastmod страницы-списка
+}
+
+var sitemapStaticPages = []sitemapPage{
+	{Path: "/", ChangeFreq: "weekly", Priority: "1.0"},
+	{Path: "/about.html", ChangeFreq: "monthly", Priority: "0.8"},
+	{Path: "/projects.html", ChangeFreq: "weekly", Priority: "0.9", Content: "projects"},
+	{Path: "/led.html", ChangeFreq: "monthly", Priority: "0.8", Content: "led"},
+	{Path: "/blog.html", ChangeFreq: "weekly", Priority: "0.8", Content: "blog"},
+	{Path: "/contact.html", ChangeFreq: "monthly", Priority: "0.7"},
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Xhtml   string       `xml:"xmlns:xhtml,attr"`
+	Image   string       `xml:"xmlns:image,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc        string           `xml:"loc"`
+	LastMod    string           `xml:"lastmod,omitempty"`
+	ChangeFreq string           `xml:"changefreq,omitempty"`
+	Priority   string           `xml:"priority,omitempty"`
+	Links      []sitemapLink    `xml:"xhtml:link"`
+	Images     []sitemapImageEl `xml:"image:image"`
+}
+
+type sitemapLink struct {
+	Rel      string `xml:"rel,attr"`
+	Hreflang string `xml:"hreflang,attr"`
+	Href     string `xml:"href,attr"`
+}
+
+type sitemapImageEl struct {
+	Loc   string `xml:"image:loc"`
+	Title string `xml:"image:title,omitempty"`
+}
+
+var sitemapCache struct {
+	sync.Mutex
+	body      []byte
+	modTime   time.Time
+	changedAt time.Time // удаление не оставляет updated_at, поэтому помним время изменения
+}
+
+// contentChanged вызывается после любой записи в таблицы контента.
+func contentChanged(ct *ContentType) {
+	sitemapCache.Lock()
+	sitemapCache.body = nil
+	sitemapCache.changedAt = time.Now()
+	sitemapCache.Unlock()
+}
+
+// lastContentChange — время последнего contentChanged в этом процессе.
+func lastContentChange() time.Time {
+	sitemapCache.Lock()
+	defer sitemapCache.Unlock()
+	return sitemapCache.changedAt
+}
+
+func sitemapDate(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func langLinks(urlFor func(lang string) string, langs []string) []sitemapLink {
+	var links []sitemapLink
+	for _, l := range langs {
+		links = append(links, sitemapLink{Rel: "alternate", Hreflang: l, Href: urlFor(l)})
+	}
+	return append(links, sitemapLink{Rel: "alternate", Hreflang: "x-default", Href: urlFor("ru")})
+}
+
+// buildSitemap возвращает XML и время последнего изменения содержимого.
+func buildSitemap(rootDir string) ([]byte, time.Time, error) {
+	set := sitemapURLSet{
+		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
+		Xhtml: "http://www.w3.org/1999/xhtml",
+		Image: "http://www.google.com/schemas/sitemap-image/1.1",
+	}
+	var newest time.Time
+	latest := map[string]time.Time{}
+	var entries []sitemapURL
+
+	for _, ct := range contentTypes {
+		items, err := ct.listItems()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		for _, it := range items {
+			mod := time.Unix(it.UpdatedAt, 0)
+			if mod.After(latest[ct.Name]) {
+				latest[ct.Name] = mod
+			}
+			var langs []string
+			for _, l := range siteLangs {
+				if it.hasLang(l) {
+					langs = append(langs, l)
+				}
+			}
+			var images []sitemapImageEl
+			for _, img := range it.Images {
+				images = append(images, sitemapImageEl{Loc: assetURL(img), Title: it.imageAlt(img, "ru")})
+			}
+			urlFor := func(l string) string { return absURL(ct.localizedURL(it, l)) }
+			links := langLinks(urlFor, langs)
+			// Каждая языковая версия — отдельный <url> с полным набором альтернатив
+			for _, l := range langs {
+				u := sitemapURL{Loc: urlFor(l), ChangeFreq: "monthly", Priority: "0.7", Links: links, Images: images}
+				if it.UpdatedAt > 0 {
+					u.LastMod = sitemapDate(mod)
+				}
+				entries = append(entries, u)
+			}
+		}
+	}
+
+	for _, p := range sitemapStaticPages {
+		mod := time.Time{}
+		file := "index.html"
+		if p.Path != "/" {
+			file = p.Path[1:]
+		}
+		if st, err := os.Stat(filepath.Join(rootDir, file)); err == nil {
+			mod = st.ModTime()
+		}
+		if t := latest[p.Content]; t.After(mod) {
+			mod = t
+		}
+		urlFor := func(l string) string {
+			if l == "ru" {
+				return absURL(p.Path)
+			}
+			return absURL(p.Path + "?lang=" + l)
+		}
+		u := sitemapURL{Loc: urlFor("ru"), ChangeFreq: p.ChangeFreq, Priority: p.Priority, Links: langLinks(urlFor, siteLangs)}
+		if !mod.IsZero() {
+			u.LastMod = sitemapDate(mod)
+		}
+		if mod.After(newest) {
+			newest = mod
+		}
+		set.URLs = append(set.URLs, u)
+	}
+	set.URLs = append(set.URLs, entries...)
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(set); err != nil {
+		return nil, time.Time{}, err
+	}
+	return buf.Bytes(), newest, nil
+}
+
+func handleSitemap(rootDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sitemapCache.Lock()
+		body, modTime := sitemapCache.body, sitemapCache.modTime
+		if body == nil {
+			var err error
+			body, modTime, err = buildSitemap(rootDir)
+			if err != nil {
+				sitemapCache.Unlock()
+				log.Println("Sitemap error:", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			if sitemapCache.changedAt.After(modTime) {
+				modTime = sitemapCache.changedAt
+			}
+			sitemapCache.body, sitemapCache.modTime = body, modTime
+		}
+		sitemapCache.Unlock()
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		http.ServeContent(w, r, "sitemap.xml", modTime, bytes.NewReader(body))
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// --- SLUGS ---
+// У blog и projects есть slug на каждом языке (slug, slug_uz, slug_en).
+// Все когда-либо выданные slug'и лежат в таблице slugs: current=1 — актуальный,
+// current=0 — старый, по нему отдаём 301 на актуальный slug того же языка.
+
+const maxSlugLen = 80
+
+var slugTranslit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya",
+	// узбекская кириллица
+	'ў': "o", 'қ': "q", 'ғ': "g", 'ҳ': "h",
+}
+
+var slugDashes = regexp.MustCompile(`-+`)
+
+// slugify транслитерирует кириллицу и оставляет только [a-z0-9-].
+// Апострофы узбекской латиницы (oʻ, gʻ) просто выбрасываются.
+func slugify(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if t, ok := slugTranslit[r]; ok {
+			b.WriteString(t)
+			continue
+		}
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '\'' || r == 'ʻ' || r == 'ʼ' || r == '’' || r == '‘' || r == '`':
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r):
+			b.WriteByte('-')
+		}
+	}
+	slug := strings.Trim(slugDashes.ReplaceAllString(b.String(), "-"), "-")
+	if len(slug) > maxSlugLen {
+		slug = strings.Trim(slug[:maxSlugLen], "-")
+	}
+	return slug
+}
+
+// slugLang: slug -> ru, slug_uz -> uz, slug_en -> en
+func slugLang(field string) string {
+	switch {
+	case strings.HasSuffix(field, "_uz"):
+		return "uz"
+	case strings.HasSuffix(field, "_en"):
+		return "en"
+	}
+	return "ru"
+}
+
+func slugFieldForLang(lang string) string {
+	switch lang {
+	case "uz":
+		return "slug_uz"
+	case "en":
+		return "slug_en"
+	}
+	return "slug"
+}
+
+func (ct *ContentType) hasSlugs() bool {
+	for _, f := range ct.Fields {
+		if f.SlugOf != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type queryRowExecer interface {
+	queryExecer
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// slugOwner возвращает запись и язык, за которыми закреплён slug.
+func (ct *ContentType) slugOwner(q queryRowExecer, slug string) (itemID int, lang string, current bool, err error) {
+	err = q.QueryRow("SELECT item_id, lang, current FROM slugs WHERE type = ? AND slug = ?", ct.Name, slug).Scan(&itemID, &lang, &current)
+	return
+}
+
+// syncSlugs пересчитывает slug'и записи после сохранения. Явно переданный
+// slug имеет приоритет, иначе он строится из заголовка нужного языка.
+// Если заголовок не менялся, текущий slug сохраняется (в т.ч. с суффиксом -2).
+func (ct *ContentType) syncSlugs(q queryRowExecer, it *Item) error {
+	if !ct.hasSlugs() {
+		return nil
+	}
+	var cur map[string]string
+	cur, err := ct.currentSlugs(q, it.ID)
+	if err != nil {
+		return err
+	}
+	taken := map[string]bool{}
+	for _, f := range ct.Fields {
+		if f.SlugOf == "" {
+			continue
+		}
+		lang := slugLang(f.Name)
+		base := slugify(it.Text(f.Name))
+		if base == "" {
+			base = slugify(it.Text(f.SlugOf))
+		}
+		old := cur[lang]
+		if base == "" {
+			it.Values[f.Name] = old
+			taken[old] = true
+			continue
+		}
+		slug := ""
+		if old != "" && !taken[old] && (old == base || strings.HasPrefix(old, base+"-") && strings.Trim(strings.TrimPrefix(old, base+"-"), "0123456789") == "") {
+			slug = old
+		} else {
+			slug, err = ct.freeSlug(q, base, it.ID, lang, taken)
+			if err != nil {
+				return err
+			}
+		}
+		taken[slug] = true
+		it.Values[f.Name] = slug
+		if slug == old {
+			continue
+		}
+		if old != "" {
+			if _, err := q.Exec("UPDATE slugs SET current = 0 WHERE type = ? AND slug = ?", ct.Name, old); err != nil {
+				return err
+			}
+		}
+		_, err = q.Exec(`INSERT INTO slugs (type, slug, item_id, lang, current, created_at) VALUES (?, ?, ?, ?, 1, ?)
+			ON CONFLICT(type, slug) DO UPDATE SET item_id = excluded.item_id, lang = excluded.lang, current = 1`,
+			ct.Name, slug, it.ID, lang, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+		if _, err := q.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ?", ct.Table, f.Name), slug, it.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freeSlug подбирает base, base-2, base-3... не занятый другой записью.
+// Свои старые slug'и той же записи можно переиспользовать.
+func (ct *ContentType) freeSlug(q queryRowExecer, base string, itemID int, lang string, taken map[string]bool) (string, error) {
+	for n := 1; ; n++ {
+		slug := base
+		if n > 1 {
+			suffix := fmt.Sprintf("-%d", n)
+			if len(base)+len(suffix) > maxSlugLen {
+				slug = strings.Trim(base[:maxSlugLen-len(suffix)], "-")
+			}
+			slug += suffix
+		}
+		if taken[slug] {
+			continue
+		}
+		owner, _, current, err := ct.slugOwner(q, slug)
+		if err == sql.ErrNoRows {
+			return slug, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if owner == itemID && !current {
+			return slug, nil
+		}
+	}
+}
+
+func (ct *ContentType) currentSlugs(q queryRowExecer, itemID int) (map[string]string, error) {
+	rows, err := q.Query("SELECT lang, slug FROM slugs WHERE type = ? AND item_id = ? AND current = 1", ct.Name, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]string{}
+	for rows.Next() {
+		var lang, slug string
+		if err := rows.Scan(&lang, &slug); err != nil {
+			return nil, err
+		}
+		out[lang] = slug
+	}
+	return out, rows.Err()
+}
+
+// resolveSlug находит запись по slug. Для старого slug'а redirect содержит
+// актуальный slug того же языка.
+func (ct *ContentType) resolveSlug(slug string) (itemID int, lang string, redirect string, err error) {
+	itemID, lang, current, err := ct.slugOwner(db, slug)
+	if err != nil {
+		return 0, "", "", err
+	}
+	if !current {
+		cur, err := ct.currentSlugs(db, itemID)
+		if err != nil {
+			return 0, "", "", err
+		}
+		redirect = cur[lang]
+		if redirect == "" {
+			redirect = cur["ru"]
+		}
+	}
+	return itemID, lang, redirect, nil
+}
+
+// GET /api/{type}/by-slug/{slug}
+func (ct *ContentType) handleBySlug(w http.ResponseWriter, r *http.Request, slug string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	itemID, _, redirect, err := ct.resolveSlug(slug)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if redirect != "" {
+		http.Redirect(w, r, "/api/"+ct.Name+"/by-slug/"+redirect, http.StatusMovedPermanently)
+		return
+	}
+	it, err := ct.getItem(fmt.Sprint(itemID))
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	attachImageInfos(it)
+	writeJSON(w, http.StatusOK, it)
+}
+
+// serveSlugPage обслуживает /blog/{slug} и /projects/{slug}: рендерит страницу
+// типа (см. ssr.go) или отдаёт 301 со старого slug'а. Возвращает false, если путь не про slug.
+func serveSlugPage(w http.ResponseWriter, r *http.Request, rootDir string) bool {
+	for _, ct := range contentTypes {
+		if !ct.hasSlugs() {
+			continue
+		}
+		slug, ok := strings.CutPrefix(r.URL.Path, "/"+ct.Name+"/")
+		if !ok || slug == "" || strings.Contains(slug, "/") {
+			continue
+		}
+		itemID, lang, redirect, err := ct.resolveSlug(slug)
+		if err != nil {
+			ct.renderContentPage(w, rootDir, nil, "", true)
+			return true
+		}
+		if redirect != "" {
+			target := "/" + ct.Name + "/" + redirect
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return true
+		}
+		it, err := ct.getItem(fmt.Sprint(itemID))
+		if err != nil {
+			ct.renderContentPage(w, rootDir, nil, "", true)
+			return true
+		}
+		ct.renderContentPage(w, rootDir, it, requestLang(r, lang), false)
+		return true
+	}
+	return false
+}
+
+// backfillSlugs выдаёт slug'и уже существующим записям (для миграции).
+// Читает только id и заголовки: selectSQL зависит от более поздних миграций.
+func backfillSlugs(names ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, name := range names {
+			ct := contentTypeByName(name)
+			var titles []string
+			for _, f := range ct.Fields {
+				if f.SlugOf != "" {
+					titles = append(titles, f.SlugOf)
+				}
+			}
+			exprs := make([]string, len(titles))
+			for i, t := range titles {
+				exprs[i] = "IFNULL(" + t + ",'')"
+			}
+			rows, err := tx.Query("SELECT id, " + strings.Join(exprs, ", ") + " FROM " + ct.Table + " ORDER BY id")
+			if err != nil {
+				return err
+			}
+			var items []*Item
+			for rows.Next() {
+				it := ct.newItem()
+				vals := make([]string, len(titles))
+				dest := []interface{}{&it.ID}
+				for i := range vals {
+					dest = append(dest, &vals[i])
+				}
+				if err := rows.Scan(dest...); err != nil {
+					rows.Close()
+					return err
+				}
+				for i, t := range titles {
+					it.Values[t] = vals[i]
+				}
+				items = append(items, it)
+			}
+			rows.Close()
+			for _, it := range items {
+				if err := ct.syncSlugs(tx, it); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
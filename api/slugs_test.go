@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"Привет, мир!", "privet-mir"},
+		{"Щука и ёжик", "shchuka-i-yozhik"},
+		{"Объявление: съёмка", "obyavlenie-syomka"},
+		{"Цех №5 — хит", "tsekh-5-khit"},
+		{"Юля & Яна", "yulya-yana"},
+		// Узбекская кириллица и латиница с апострофами
+		{"Ўзбекистон ғалаба қилди", "ozbekiston-galaba-qildi"},
+		{"Ҳаёт", "hayot"},
+		{"Oʻzbekiston gʻalaba", "ozbekiston-galaba"},
+		{"O'zbekiston g‘alaba", "ozbekiston-galaba"},
+		{"Hello World 2024", "hello-world-2024"},
+		{"  --Already--slugged--  ", "already-slugged"},
+		{"LED-экраны / Ташкент", "led-ekrany-tashkent"},
+		{"!!!", ""},
+		{"", ""},
+	} {
+		if got := slugify(tc.in); got != tc.want {
+			t.Errorf("slugify(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	long := slugify(strings.Repeat("слово ", 40))
+	if len(long) > maxSlugLen || strings.HasSuffix(long, "-") || !strings.HasPrefix(long, "slovo-slovo") {
+		t.Errorf("long slug %q (%d chars)", long, len(long))
+	}
+}
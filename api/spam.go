@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// --- SPAM PROTECTION ---
+// Защита /api/form:
+//
+//	FORM_RATE_LIMIT_IP=5, FORM_RATE_LIMIT_PHONE=3 за FORM_RATE_WINDOW=1h — сверх лимита 429
+//	  и заявка не сохраняется (0 — без лимита);
+//	скрытое поле hp_extra (honeypot) — люди его не видят и не заполняют; имя не из
+//	словаря автозаполнения (website, company...), иначе браузер заполнит его сам;
+//	fill_ms — сколько форма была открыта до отправки, меньше FORM_MIN_FILL_TIME=3s — бот;
+//	  без fill_ms (закэшированная старая страница, интеграции) заявка не помечается;
+//	CAPTCHA_PROVIDER=turnstile|hcaptcha|recaptcha + CAPTCHA_SITE_KEY/CAPTCHA_SECRET — проверка
+//	  captcha_token (CAPTCHA_VERIFY_URL подменяет адрес проверки).
+//
+// Подозрительная заявка сохраняется с флагом spam и причиной, в Telegram не уходит,
+// а посетитель получает обычный ok — чтобы бот не подбирал обход.
+
+// rateLimiter — скользящее окно в памяти: не больше max событий на ключ за window.
+type rateLimiter struct {
+	mu        sync.Mutex
+	max       int
+	window    time.Duration
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newRateLimiter(max int, window time.Duration) *rateLimiter {
+	return &rateLimiter{max: max, window: window, hits: map[string][]time.Time{}}
+}
+
+// allow учитывает событие по ключу; при превышении — false и через сколько можно снова.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if l.max <= 0 || key == "" {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff := now.Add(-l.window)
+	// Раз в окно выбрасываем ключи без свежих событий
+	if now.Sub(l.lastSweep) > l.window {
+		for k, ts := range l.hits {
+			if len(ts) == 0 || !ts[len(ts)-1].After(cutoff) {
+				delete(l.hits, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	ts := l.hits[key]
+	for len(ts) > 0 && !ts[0].After(cutoff) {
+		ts = ts[1:]
+	}
+	if len(ts) >= l.max {
+		l.hits[key] = ts
+		return false, ts[0].Sub(cutoff)
+	}
+	l.hits[key] = append(ts, now)
+	return true, 0
+}
+
+// reset забывает события по ключу.
+func (l *rateLimiter) reset(key string) {
+	l.mu.Lock()
+	delete(l.hits, key)
+	l.mu.Unlock()
+}
+
+func envInt(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+		log.Printf("Invalid %s %q, using %d", name, v, def)
+	}
+	return def
+}
+
+var (
+	formIPLimiter    *rateLimiter
+	formPhoneLimiter *rateLimiter
+	formMinFillTime  time.Duration
+	formCaptcha      captchaVerifier
+)
+
+// initSpamProtection читает настройки защиты формы.
+func initSpamProtection() error {
+	window := envDuration("FORM_RATE_WINDOW", time.Hour)
+	formIPLimiter = newRateLimiter(envInt("FORM_RATE_LIMIT_IP", 5), window)
+	formPhoneLimiter = newRateLimiter(envInt("FORM_RATE_LIMIT_PHONE", 3), window)
+	formMinFillTime = 3 * time.Second
+	if v := os.Getenv("FORM_MIN_FILL_TIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid FORM_MIN_FILL_TIME %q", v)
+		}
+		formMinFillTime = d
+	}
+	c, err := newCaptchaFromEnv()
+	if err != nil {
+		return err
+	}
+	formCaptcha = c
+	return nil
+}
+
+// phoneKey — цифры телефона: "+998 90 123-45-67" и "998901234567" — один ключ.
+func phoneKey(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// checkFormRate применяет лимиты по IP и телефону; false — ответ 429 уже отправлен.
+func checkFormRate(w http.ResponseWriter, ip, phone string) bool {
+	now := time.Now()
+	ok, wait := formIPLimiter.allow(ip, now)
+	if ok {
+		ok, wait = formPhoneLimiter.allow(phoneKey(phone), now)
+	}
+	if ok {
+		return true
+	}
+	log.Printf("[form] rate limit: ip %s phone %s", ip, phone)
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return false
+}
+
+// spamReason — почему заявка похожа на спам; "" — не похожа.
+func spamReason(req *FormRequest, ip string) string {
+	if strings.TrimSpace(req.Honeypot) != "" {
+		return "honeypot"
+	}
+	if req.FillMS == nil {
+		// Само по себе не повод считать спамом — только отметка в логе
+		log.Printf("[form] no fill_ms from %s", ip)
+	} else if formMinFillTime > 0 && time.Duration(*req.FillMS)*time.Millisecond < formMinFillTime {
+		return "too_fast"
+	}
+	if formCaptcha != nil {
+		ok, err := formCaptcha.Verify(req.CaptchaToken, ip)
+		if err != nil {
+			// Проверка недоступна — заявку не теряем и не помечаем
+			log.Println("[form] captcha verify error:", err)
+			return ""
+		}
+		if !ok {
+			return "captcha"
+		}
+	}
+	return ""
+}
+
+// --- CAPTCHA ---
+
+// captchaVerifier проверяет токен, полученный виджетом на странице.
+type captchaVerifier interface {
+	Provider() string
+	SiteKey() string
+	Verify(token, ip string) (bool, error)
+}
+
+// siteVerifyCaptcha — Turnstile, hCaptcha и reCAPTCHA проверяются одинаково:
+// POST secret/response/remoteip, в ответе {"success": bool}.
+type siteVerifyCaptcha struct {
+	provider  string
+	verifyURL string
+	siteKey   string
+	secret    string
+	client    *http.Client
+}
+
+var captchaVerifyURLs = map[string]string{
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+}
+
+func newCaptchaFromEnv() (captchaVerifier, error) {
+	provider := os.Getenv("CAPTCHA_PROVIDER")
+	if provider == "" {
+		return nil, nil
+	}
+	verifyURL, ok := captchaVerifyURLs[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown CAPTCHA_PROVIDER %q", provider)
+	}
+	if u := os.Getenv("CAPTCHA_VERIFY_URL"); u != "" {
+		verifyURL = u
+	}
+	c := &siteVerifyCaptcha{
+		provider:  provider,
+		verifyURL: verifyURL,
+		siteKey:   os.Getenv("CAPTCHA_SITE_KEY"),
+		secret:    os.Getenv("CAPTCHA_SECRET"),
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	if c.siteKey == "" || c.secret == "" {
+		return nil, fmt.Errorf("CAPTCHA_SITE_KEY and CAPTCHA_SECRET are required for CAPTCHA_PROVIDER=%s", provider)
+	}
+	return c, nil
+}
+
+func (c *siteVerifyCaptcha) Provider() string { return c.provider }
+func (c *siteVerifyCaptcha) SiteKey() string  { return c.siteKey }
+
+func (c *siteVerifyCaptcha) Verify(token, ip string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+	form := url.Values{"secret": {c.secret}, "response": {token}}
+	if ip != "" {
+		form.Set("remoteip", ip)
+	}
+	resp, err := c.client.PostForm(c.verifyURL, form)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s siteverify: %s", c.provider, resp.Status)
+	}
+	var body struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return false, err
+	}
+	return body.Success, nil
+}
+
+// GET /api/form/config — что нужно странице с формой: виджет CAPTCHA и минимальное время.
+func handleFormConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := map[string]interface{}{"honeypot": "website"}
+	if formCaptcha != nil {
+		cfg["captcha"] = map[string]string{"provider": formCaptcha.Provider(), "site_key": formCaptcha.SiteKey()}
+	}
+	writeJSON(w, http.StatusOK, cfg)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpamReason(t *testing.T) {
+	prevFill, prevCaptcha := formMinFillTime, formCaptcha
+	formMinFillTime, formCaptcha = 3*time.Second, nil
+	t.Cleanup(func() { formMinFillTime, formCaptcha = prevFill, prevCaptcha })
+
+	ms := func(n int64) *int64 { return &n }
+	for _, tc := range []struct {
+		name string
+		req  FormRequest
+		want string
+	}{
+		{"human", FormRequest{FillMS: ms(15000)}, ""},
+		{"honeypot", FormRequest{Honeypot: "http://spam.example", FillMS: ms(15000)}, "honeypot"},
+		{"blank honeypot", FormRequest{Honeypot: "  ", FillMS: ms(15000)}, ""},
+		{"too fast", FormRequest{FillMS: ms(800)}, "too_fast"},
+		{"at threshold", FormRequest{FillMS: ms(3000)}, ""},
+		// Старые страницы из кэша и интеграции fill_ms не присылают
+		{"no fill_ms", FormRequest{}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := spamReason(&tc.req, "203.0.113.1"); got != tc.want {
+				t.Errorf("spamReason = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	formMinFillTime = 0
+	if got := spamReason(&FormRequest{FillMS: ms(1)}, ""); got != "" {
+		t.Errorf("FORM_MIN_FILL_TIME=0: spamReason = %q", got)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	now := time.Now()
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := l.allow("ip", now.Add(time.Duration(i)*time.Second)); ok != want {
+			t.Errorf("hit %d: allow = %v, want %v", i+1, ok, want)
+		}
+	}
+	if ok, _ := l.allow("other", now); !ok {
+		t.Error("keys share a limit")
+	}
+	// Окно сдвинулось — первое событие больше не считается
+	if ok, _ := l.allow("ip", now.Add(time.Minute+time.Second)); !ok {
+		t.Error("not allowed after the window passed")
+	}
+	if ok, _ := newRateLimiter(0, time.Minute).allow("ip", now); !ok {
+		t.Error("zero limit must allow everything")
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// --- SSR ---
+// blog-post.html и project-detail.html — шаблоны html/template. Сервер сам
+// подставляет title, meta description, og:*, hreflang и JSON-LD из строки БД,
+// чтобы краулеры и превью ссылок в Telegram видели готовую страницу.
+// Клиентский JS берёт данные из #ssr-data вместо повторного запроса к API.
+
+var siteLangs = []string{"ru", "uz", "en"}
+
+var ogLocales = map[string]string{"ru": "ru_RU", "uz": "uz_UZ", "en": "en_US"}
+
+// siteURL — внешний адрес сайта для canonical/og:url (SITE_URL в .env).
+func siteURL() string {
+	if u := strings.TrimRight(os.Getenv("SITE_URL"), "/"); u != "" {
+		return u
+	}
+	return "https://influencelab.uz"
+}
+
+func absURL(p string) string {
+	if p == "" || strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://") {
+		return p
+	}
+	return siteURL() + "/" + strings.TrimPrefix(p, "/")
+}
+
+// assetURL — абсолютный адрес файла; имена загрузок могут содержать пробелы
+// и кириллицу, поэтому путь экранируется.
+func assetURL(p string) string {
+	if p == "" || strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://") {
+		return p
+	}
+	return absURL((&url.URL{Path: "/" + strings.TrimPrefix(p, "/")}).EscapedPath())
+}
+
+func validLang(lang string) bool {
+	return lang == "ru" || lang == "uz" || lang == "en"
+}
+
+// localText возвращает поле на языке lang, если перевод заполнен, иначе русское.
+func (it *Item) localText(name, lang string) string {
+	if lang != "ru" {
+		if s := it.Text(name + "_" + lang); s != "" {
+			return s
+		}
+	}
+	return it.Text(name)
+}
+
+// hasLang: есть ли у записи перевод на lang (русский есть всегда).
+func (it *Item) hasLang(lang string) bool {
+	return lang == "ru" || it.Text("title_"+lang) != ""
+}
+
+// localizedURL — адрес языковой версии записи. Если своего slug'а у языка
+// нет, берётся русский и добавляется ?lang=.
+func (ct *ContentType) localizedURL(it *Item, lang string) string {
+	if !ct.hasSlugs() {
+		u := fmt.Sprintf("/%s?id=%d", ct.Page, it.ID)
+		if lang != "ru" {
+			u += "&lang=" + lang
+		}
+		return u
+	}
+	slug := it.Text(slugFieldForLang(lang))
+	own := slug != ""
+	if !own {
+		slug = it.Text("slug")
+	}
+	u := "/" + ct.Name + "/" + url.PathEscape(slug)
+	if !own && lang != "ru" {
+		u += "?lang=" + lang
+	}
+	return u
+}
+
+// Тексты мета-тегов, как в seo-templates.js
+type seoTexts struct {
+	Title, Description, OGTitle func(title, desc string) string
+}
+
+func cutText(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		r = r[:n]
+	}
+	return string(r)
+}
+
+var seoTemplates = map[string]map[string]seoTexts{
+	"blog": {
+		"ru": {
+			Title: func(t, d string) string {
+				return t + " - Блог Influence Lab | Инфлюенсер-маркетинг в Узбекистане"
+			},
+			Description: func(t, d string) string {
+				return cutText(d, 120) + "... Читайте больше о " + strings.ToLower(t) + " в блоге Influence Lab - ведущего агентства инфлюенсер-маркетинга в Узбекистане."
+			},
+			OGTitle: func(t, d string) string { return t + " | Influence Lab Blog" },
+		},
+		"uz": {
+			Title: func(t, d string) string { return t + " - Influence Lab Blogi | O'zbekistonda influencer marketing" },
+			Description: func(t, d string) string {
+				return cutText(d, 120) + "... " + strings.ToLower(t) + " haqida ko'proq o'qing Influence Lab blogida - O'zbekistondagi yetakchi influencer marketing agentligi."
+			},
+			OGTitle: func(t, d string) string { return t + " | Influence Lab Blogi" },
+		},
+		"en": {
+			Title: func(t, d string) string { return t + " - Influence Lab Blog | Influencer Marketing in Uzbekistan" },
+			Description: func(t, d string) string {
+				return cutText(d, 120) + "... Read more about " + strings.ToLower(t) + " on Influence Lab blog - leading influencer marketing agency in Uzbekistan."
+			},
+			OGTitle: func(t, d string) string { return t + " | Influence Lab Blog" },
+		},
+	},
+	"projects": {
+		"ru": {
+			Title: func(t, d string) string {
+				return t + " - Проект Influence Lab | Кейсы инфлюенсер-маркетинга"
+			},
+			Description: func(t, d string) string {
+				return cutText(d, 120) + "... Смотрите кейс проекта \"" + t + "\" от Influence Lab - успешные примеры инфлюенсер-маркетинга в Узбекистане."
+			},
+			OGTitle: func(t, d string) string { return t + " | Influence Lab Project" },
+		},
+		"uz": {
+			Title: func(t, d string) string { return t + " - Influence Lab Loyihasi | Influencer marketing keyslari" },
+			Description: func(t, d string) string {
+				return cutText(d, 120) + "... \"" + t + "\" loyihasi keysini ko'ring Influence Lab'dan - O'zbekistonda muvaffaqiyatli influencer marketing misollari."
+			},
+			OGTitle: func(t, d string) string { return t + " | Influence Lab Loyihasi" },
+		},
+		"en": {
+			Title: func(t, d string) string { return t + " - Influence Lab Project | Influencer Marketing Cases" },
+			Description: func(t, d string) string {
+				return cutText(d, 120) + "... See case study of \"" + t + "\" project by Influence Lab - successful influencer marketing examples in Uzbekistan."
+			},
+			OGTitle: func(t, d string) string { return t + " | Influence Lab Project" },
+		},
+	},
+}
+
+type pageAlternate struct {
+	Hreflang string
+	URL      string
+}
+
+// pageData — данные шаблона страницы записи. Без записи (Found=false)
+// рендерится прежняя «пустая» страница, которую заполняет JS.
+type pageData struct {
+	Found, NotFound    bool
+	Lang, Locale       string
+	Title              string
+	Description        string
+	OGTitle            string
+	OGDescription      string
+	TwitterTitle       string
+	TwitterDescription string
+	Canonical          string
+	Image              string
+	Alternates         []pageAlternate
+	OtherLocales       []string
+	JSONLD             map[string]interface{}
+	Heading, Text      string
+	Img                string
+	ImgAlt             string
+	ImgPosition        string                 // object-position по фокусу картинки
+	Data               map[string]interface{} // запись на выбранном языке для JS
+}
+
+func defaultPageData(ct *ContentType) *pageData {
+	return &pageData{
+		Lang:               "ru",
+		Locale:             ogLocales["ru"],
+		Title:              "Загрузка...",
+		Description:        "Загрузка...",
+		OGTitle:            "Загрузка...",
+		OGDescription:      "Загрузка...",
+		TwitterTitle:       "Загрузка...",
+		TwitterDescription: "Загрузка...",
+		Canonical:          absURL(ct.Page),
+		Image:              absURL("/img/logo.png"),
+	}
+}
+
+func (ct *ContentType) buildPageData(it *Item, lang string) *pageData {
+	pd := defaultPageData(ct)
+	pd.Found = true
+	pd.Lang = lang
+	pd.Locale = ogLocales[lang]
+	title := it.localText("title", lang)
+	desc := it.localText("description", lang)
+	if tpl, ok := seoTemplates[ct.Name][lang]; ok {
+		pd.Title = tpl.Title(title, desc)
+		pd.Description = tpl.Description(title, desc)
+		pd.OGTitle = tpl.OGTitle(title, desc)
+	} else {
+		pd.Title, pd.Description, pd.OGTitle = title+" - Influence Lab", cutText(desc, 160), title
+	}
+	pd.OGDescription = cutText(desc, 150) + "..."
+	pd.TwitterTitle = title + " - Influence Lab"
+	pd.TwitterDescription = cutText(desc, 100) + "..."
+	pd.Canonical = absURL(ct.localizedURL(it, lang))
+	if it.Img != "" {
+		pd.Image = assetURL(it.Img)
+	}
+	for _, l := range siteLangs {
+		if !it.hasLang(l) {
+			continue
+		}
+		pd.Alternates = append(pd.Alternates, pageAlternate{Hreflang: l, URL: absURL(ct.localizedURL(it, l))})
+		if l != lang {
+			pd.OtherLocales = append(pd.OtherLocales, ogLocales[l])
+		}
+	}
+	pd.Alternates = append(pd.Alternates, pageAlternate{Hreflang: "x-default", URL: absURL(ct.localizedURL(it, "ru"))})
+
+	ldType := "BlogPosting"
+	if ct.Name != "blog" {
+		ldType = "CreativeWork"
+	}
+	org := map[string]interface{}{"@type": "Organization", "name": "Influence Lab", "url": siteURL()}
+	pd.JSONLD = map[string]interface{}{
+		"@context":         "https://schema.org",
+		"@type":            ldType,
+		"headline":         title,
+		"description":      desc,
+		"url":              pd.Canonical,
+		"mainEntityOfPage": pd.Canonical,
+		"inLanguage":       lang,
+		"author":           org,
+		"publisher": map[string]interface{}{
+			"@type": "Organization",
+			"name":  "Influence Lab",
+			"logo":  map[string]interface{}{"@type": "ImageObject", "url": absURL("/img/logo.png")},
+		},
+	}
+	if it.Img != "" {
+		pd.JSONLD["image"] = map[string]interface{}{"@type": "ImageObject", "url": pd.Image}
+	}
+
+	pd.Heading = title
+	pd.Text = desc
+	pd.Img = it.Img
+	pd.ImgAlt = it.imageAlt(it.Img, lang)
+	pd.ImgPosition = it.imagePosition(it.Img)
+	details := make([]map[string]interface{}, 0, len(it.Images))
+	for _, u := range it.Images {
+		d := map[string]interface{}{"url": u, "alt": it.imageAlt(u, lang), "position": it.imagePosition(u)}
+		if m, ok := it.ImageMeta[u]; ok && m.caption(lang) != "" {
+			d["caption"] = m.caption(lang)
+		}
+		details = append(details, d)
+	}
+	data := map[string]interface{}{
+		"id":            it.ID,
+		"lang":          lang,
+		"img":           it.Img,
+		"images":        nonNil(it.Images),
+		"image_details": details,
+		"title":         title,
+		"description":   desc,
+	}
+	for _, f := range ct.Fields {
+		if f.Kind == FieldList {
+			data[f.Name] = nonNil(it.List(f.Name))
+		}
+	}
+	if ct.hasSlugs() {
+		data["slug"] = it.Text(slugFieldForLang(lang))
+	}
+	pd.Data = data
+	return pd
+}
+
+// renderContentPage рендерит страницу типа; it == nil — пустая страница
+// (notFound — с кодом 404).
+func (ct *ContentType) renderContentPage(w http.ResponseWriter, rootDir string, it *Item, lang string, notFound bool) {
+	tpl, err := template.ParseFiles(filepath.Join(rootDir, ct.Page))
+	if err != nil {
+		log.Println("Template error:", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	pd := defaultPageData(ct)
+	if it != nil {
+		pd = ct.buildPageData(it, lang)
+	}
+	pd.NotFound = notFound
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, pd); err != nil {
+		log.Println("Template error:", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if notFound {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	buf.WriteTo(w)
+}
+
+// requestLang: ?lang= или язык по умолчанию.
+func requestLang(r *http.Request, def string) string {
+	if l := r.URL.Query().Get("lang"); validLang(l) {
+		return l
+	}
+	if validLang(def) {
+		return def
+	}
+	return "ru"
+}
+
+// servePageByID обслуживает /{page}.html?id=N (и /{page}?id=N). Для типов со
+// slug'ами отдаёт 301 на /{type}/{slug}. Возвращает false, если путь не страница типа.
+func servePageByID(w http.ResponseWriter, r *http.Request, rootDir string) bool {
+	for _, ct := range contentTypes {
+		if !ct.SSR {
+			continue
+		}
+		if r.URL.Path != "/"+ct.Page && r.URL.Path != "/"+strings.TrimSuffix(ct.Page, ".html") {
+			continue
+		}
+		id := r.URL.Query().Get("id")
+		if _, err := strconv.Atoi(id); err != nil {
+			ct.renderContentPage(w, rootDir, nil, "", id != "")
+			return true
+		}
+		it, err := ct.getItem(id)
+		if err != nil {
+			ct.renderContentPage(w, rootDir, nil, "", true)
+			return true
+		}
+		lang := requestLang(r, "ru")
+		if ct.hasSlugs() && it.Text("slug") != "" {
+			http.Redirect(w, r, ct.localizedURL(it, lang), http.StatusMovedPermanently)
+			return true
+		}
+		ct.renderContentPage(w, rootDir, it, lang, false)
+		return true
+	}
+	return false
+}
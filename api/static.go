@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// --- STATIC FILES ---
+// Статика раздаётся из корня проекта (на уровень выше api), поэтому всё,
+// что не попало в allow-list, отдаёт 404: api/, .env, influence.db, .DS_Store и т.п.
+
+// Каталоги, которые раздаются целиком
+var staticAllowedDirs = []string{"/img/", "/components/", "/video/", "/admin/"}
+
+// Расширения файлов, разрешённые в корне сайта (страницы, фавиконки, verification-файлы)
+var staticAllowedRootExts = map[string]bool{
+	".html":        true,
+	".js":          true,
+	".css":         true,
+	".ico":         true,
+	".png":         true,
+	".webmanifest": true,
+}
+
+// Отдельные файлы в корне
+var staticAllowedRootFiles = map[string]bool{
+	"/robots.txt": true,
+}
+
+// Запрещено всегда, даже внутри разрешённых каталогов
+var staticDeniedExts = map[string]bool{
+	".db":      true,
+	".sqlite":  true,
+	".sqlite3": true,
+	".go":      true,
+	".mod":     true,
+	".sum":     true,
+	".env":     true,
+	".md":      true,
+	".jsonl":   true,
+}
+
+// staticDenyPatterns — дополнительный deny-list из STATIC_DENY (через запятую,
+// шаблоны path.Match, сравниваются с путём и с именем файла), например:
+// STATIC_DENY=/seo-test.html,/admin/*,*.bak
+var staticDenyPatterns []string
+
+func loadStaticConfig() {
+	staticDenyPatterns = nil
+	for _, p := range strings.Split(os.Getenv("STATIC_DENY"), ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			log.Printf("[static] Ignoring bad STATIC_DENY pattern %q: %v", p, err)
+			continue
+		}
+		staticDenyPatterns = append(staticDenyPatterns, p)
+	}
+}
+
+// staticAllowed решает, можно ли отдать путь urlPath (уже path.Clean) как статику.
+func staticAllowed(urlPath string) bool {
+	if urlPath == "/" {
+		return true
+	}
+	// Дотфайлы и дот-каталоги на любом уровне
+	for _, seg := range strings.Split(strings.Trim(urlPath, "/"), "/") {
+		if strings.HasPrefix(seg, ".") {
+			return false
+		}
+	}
+	if strings.HasPrefix(urlPath+"/", "/api/") {
+		return false
+	}
+	base := path.Base(urlPath)
+	ext := strings.ToLower(path.Ext(base))
+	if staticDeniedExts[ext] {
+		return false
+	}
+	for _, p := range staticDenyPatterns {
+		if ok, _ := path.Match(p, urlPath); ok {
+			return false
+		}
+		if ok, _ := path.Match(p, base); ok {
+			return false
+		}
+	}
+	for _, dir := range staticAllowedDirs {
+		if strings.HasPrefix(urlPath, dir) {
+			return true
+		}
+	}
+	if strings.Count(urlPath, "/") == 1 {
+		return staticAllowedRootFiles[urlPath] || staticAllowedRootExts[ext]
+	}
+	return false
+}
+
+// staticHandler раздаёт только разрешённые файлы и не показывает листинг каталогов.
+func staticHandler(rootDir string) http.Handler {
+	fileServer := http.FileServer(http.Dir(rootDir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean("/" + r.URL.Path)
+		if !staticAllowed(p) {
+			http.NotFound(w, r)
+			return
+		}
+		if p != "/" {
+			full := filepath.Join(rootDir, filepath.FromSlash(p))
+			fi, err := os.Stat(full)
+			if err != nil || (fi.IsDir() && !fileExists(filepath.Join(full, "index.html"))) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+func fileExists(p string) bool {
+	fi, err := os.Stat(p)
+	return err == nil && !fi.IsDir()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticAllowed(t *testing.T) {
+	prev := staticDenyPatterns
+	t.Cleanup(func() { staticDenyPatterns = prev })
+	t.Setenv("STATIC_DENY", "/seo-test.html, /admin/*, *.bak, [bad")
+	loadStaticConfig()
+
+	for _, tc := range []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/index.html", true},
+		{"/blog-post.html", true},
+		{"/main.js", true},
+		{"/favicon.ico", true},
+		{"/robots.txt", true},
+		{"/img/logo.svg", true},
+		{"/img/2024/photo.jpg", true},
+		{"/components/header.html", true},
+		{"/video/intro.mp4", true},
+		// Дотфайлы на любом уровне
+		{"/.env", false},
+		{"/.git/config", false},
+		{"/img/.DS_Store", false},
+		{"/img/.hidden/a.png", false},
+		// Служебные каталоги и расширения
+		{"/api", false},
+		{"/api/main.go", false},
+		{"/influence.db", false},
+		{"/img/backup.sqlite3", false},
+		{"/img/DUMP.DB", false},
+		{"/README.md", false},
+		{"/requests.jsonl", false},
+		{"/go.mod", false},
+		// Не из allow-list
+		{"/notes.txt", false},
+		{"/uploads-old/a.png", false},
+		{"/secret/index.html", false},
+		// STATIC_DENY: путь, каталог, имя файла; битый шаблон пропущен
+		{"/seo-test.html", false},
+		{"/admin/index.html", false},
+		{"/admin/js/app.js", true}, // * не переходит через /
+		{"/img/old.bak", false},
+		{"/img/[bad", true},
+	} {
+		if got := staticAllowed(tc.path); got != tc.want {
+			t.Errorf("staticAllowed(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestStaticHandler(t *testing.T) {
+	prev := staticDenyPatterns
+	t.Cleanup(func() { staticDenyPatterns = prev })
+	staticDenyPatterns = nil
+
+	root := t.TempDir()
+	for name, body := range map[string]string{
+		"index.html":      "home",
+		".env":            "TOKEN=x",
+		"api/main.go":     "package main",
+		"img/a.png":       "png",
+		"img/sub/b.png":   "png",
+		"components/x.js": "js",
+	} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := staticHandler(root)
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/", http.StatusOK},
+		{"/img/a.png", http.StatusOK},
+		{"/img/missing.png", http.StatusNotFound},
+		{"/.env", http.StatusNotFound},
+		{"/api/main.go", http.StatusNotFound},
+		{"/img/../api/main.go", http.StatusNotFound},
+		{"/img/../.env", http.StatusNotFound},
+		// Листинг каталога без index.html не отдаётся
+		{"/img/", http.StatusNotFound},
+		{"/img/sub", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path = tc.path
+		h.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("GET %s: status %d, want %d", tc.path, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- STORAGE ---
+// Загрузки (и их варианты, images.go) лежат в хранилище за интерфейсом
+// Storage. Ключ — путь внутри хранилища ("<sha256>.png", "variants/..."),
+// публичный URL — UPLOADS_PUBLIC_URL + "/" + ключ; в БД хранятся URL.
+//
+//	STORAGE_DRIVER=local  (по умолчанию) файлы в UPLOADS_DIR, по умолчанию ../img/uploads
+//	STORAGE_DRIVER=s3     S3-совместимое хранилище (AWS, MinIO), см. s3.go
+//
+// UPLOADS_PUBLIC_URL по умолчанию /img/uploads для local и адрес бакета для s3.
+// Смена префикса не переписывает уже сохранённые URL.
+
+var errNotExist = errors.New("object does not exist")
+
+type StoredObject struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+type Storage interface {
+	Put(key string, r io.Reader, size int64, contentType string) error
+	Get(key string) (io.ReadCloser, error) // errNotExist, если ключа нет
+	Delete(key string) error               // отсутствие ключа — не ошибка
+	Exists(key string) (bool, error)
+	List() ([]StoredObject, error)
+}
+
+var store Storage
+
+// uploadsURL — публичный префикс загрузок без завершающего /.
+var uploadsURL = "/img/uploads"
+
+// initStorage выбирает драйвер по STORAGE_DRIVER.
+func initStorage() error {
+	driver := os.Getenv("STORAGE_DRIVER")
+	switch driver {
+	case "", "local":
+		driver = "local"
+		dir := os.Getenv("UPLOADS_DIR")
+		if dir == "" {
+			// Файлы сохраняем в корень проекта (на уровень выше api)
+			dir = filepath.Join("..", "img", "uploads")
+		}
+		store = &localStorage{dir: dir}
+	case "s3":
+		s, err := newS3StorageFromEnv()
+		if err != nil {
+			return err
+		}
+		store = s
+		uploadsURL = s.defaultURL()
+	default:
+		return fmt.Errorf("unknown STORAGE_DRIVER %q", driver)
+	}
+	if u := os.Getenv("UPLOADS_PUBLIC_URL"); u != "" {
+		uploadsURL = u
+	}
+	uploadsURL = strings.TrimRight(uploadsURL, "/")
+	log.Printf("Uploads: %s storage, public URL %s", driver, uploadsURL)
+	return nil
+}
+
+// storageURL — публичный адрес ключа.
+func storageURL(key string) string {
+	return uploadsURL + "/" + key
+}
+
+// storageKey выделяет ключ из публичного URL; false — не загрузка
+// (статика, внешний адрес) или ключ с выходом за пределы хранилища.
+func storageKey(u string) (string, bool) {
+	key, ok := strings.CutPrefix(u, uploadsURL+"/")
+	if !ok || key == "" || path.Clean("/"+key) != "/"+key {
+		return "", false
+	}
+	return key, true
+}
+
+// readStored читает объект целиком.
+func readStored(key string) ([]byte, error) {
+	rc, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// --- local ---
+
+type localStorage struct {
+	dir string
+}
+
+func (s *localStorage) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+// Put пишет во временный файл рядом и переименовывает: читатели не видят
+// недописанный файл.
+func (s *localStorage) Put(key string, r io.Reader, size int64, contentType string) error {
+	dst := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (s *localStorage) Get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, errNotExist
+	}
+	return f, err
+}
+
+func (s *localStorage) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStorage) Exists(key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *localStorage) List() ([]StoredObject, error) {
+	var out []StoredObject
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// .upload-* — недописанные файлы Put
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		st, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		out = append(out, StoredObject{Key: filepath.ToSlash(rel), Size: st.Size(), ModTime: st.ModTime()})
+		return nil
+	})
+	return out, err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- TELEGRAM ---
+// Вызовы Bot API. TELEGRAM_API_URL подменяет адрес API (по умолчанию
+// https://api.telegram.org), например на локальный фейковый сервер:
+//
+//	TELEGRAM_API_URL=http://localhost:8081 TELEGRAM_BOT_TOKEN=test TELEGRAM_CHAT_ID=1 go run -tags sqlite_fts5 .
+//
+// Кнопки под уведомлениями и вебхук для них — telegram_actions.go.
+
+var telegramClient = &http.Client{Timeout: 10 * time.Second}
+
+var errTelegramNotConfigured = errors.New("telegram is not configured")
+
+// telegramError — ответ Bot API с ok=false или не-2xx.
+type telegramError struct {
+	Status      int
+	Description string
+	RetryAfter  time.Duration // для 429 Too Many Requests
+}
+
+func (e *telegramError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("telegram: %d %s", e.Status, e.Description)
+	}
+	return fmt.Sprintf("telegram: %d", e.Status)
+}
+
+func telegramAPIURL() string {
+	if u := os.Getenv("TELEGRAM_API_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "https://api.telegram.org"
+}
+
+// telegramCall вызывает метод Bot API и возвращает поле result.
+func telegramCall(method string, params url.Values) (json.RawMessage, error) {
+	token := os.Getenv("TELEGRAM_BOT_TOKEN")
+	if token == "" {
+		return nil, errTelegramNotConfigured
+	}
+	apiURL := fmt.Sprintf("%s/bot%s/%s", telegramAPIURL(), token, method)
+	resp, err := telegramClient.PostForm(apiURL, params)
+	if err != nil {
+		// В тексте *url.Error есть адрес с токеном бота — в БД и API он попасть не должен
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return nil, uerr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		OK          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		Description string          `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil || resp.StatusCode != http.StatusOK || !body.OK {
+		return nil, &telegramError{
+			Status:      resp.StatusCode,
+			Description: body.Description,
+			RetryAfter:  time.Duration(body.Parameters.RetryAfter) * time.Second,
+		}
+	}
+	return body.Result, nil
+}
+
+// telegramButton и telegramKeyboard — InlineKeyboardMarkup Bot API.
+type telegramButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type telegramKeyboard struct {
+	InlineKeyboard [][]telegramButton `json:"inline_keyboard"`
+}
+
+func (kb *telegramKeyboard) set(params url.Values) {
+	if kb != nil {
+		b, _ := json.Marshal(kb)
+		params.Set("reply_markup", string(b))
+	}
+}
+
+// sendTelegramMessage отправляет текст; kb — кнопки под сообщением или nil.
+func sendTelegramMessage(chatID, text string, kb *telegramKeyboard) error {
+	params := url.Values{"chat_id": {chatID}, "text": {text}}
+	kb.set(params)
+	_, err := telegramCall("sendMessage", params)
+	return err
+}
+
+// editTelegramMessage заменяет текст и кнопки отправленного сообщения.
+func editTelegramMessage(chatID, messageID int64, text string, kb *telegramKeyboard) error {
+	params := url.Values{
+		"chat_id":    {strconv.FormatInt(chatID, 10)},
+		"message_id": {strconv.FormatInt(messageID, 10)},
+		"text":       {text},
+	}
+	kb.set(params)
+	_, err := telegramCall("editMessageText", params)
+	var te *telegramError
+	if errors.As(err, &te) && strings.Contains(te.Description, "message is not modified") {
+		return nil
+	}
+	return err
+}
+
+// answerCallbackQuery убирает «часики» с нажатой кнопки; alert — показать окно вместо всплывашки.
+func answerCallbackQuery(id, text string, alert bool) error {
+	_, err := telegramCall("answerCallbackQuery", url.Values{
+		"callback_query_id": {id},
+		"text":              {text},
+		"show_alert":        {strconv.FormatBool(alert)},
+	})
+	return err
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// --- TELEGRAM ACTIONS ---
+// Уведомления в Telegram приходят с кнопками «Взять», «Связались» и «Спам».
+// Нажатия Telegram присылает на вебхук:
+//
+//	POST /api/telegram/webhook   заголовок X-Telegram-Bot-Api-Secret-Token = TELEGRAM_WEBHOOK_SECRET
+//
+// Кнопки добавляются, только когда задан TELEGRAM_WEBHOOK_SECRET. Если задан и
+// TELEGRAM_WEBHOOK_URL (публичный адрес этого эндпоинта), вебхук регистрируется
+// через setWebhook при запуске; иначе его регистрируют вручную.
+//
+// Менеджер действует от имени пользователя админки, к которому привязан его
+// Telegram (PUT /api/users/{id} {"telegram_id": 123456}); непривязанному бот
+// показывает его ID. После нажатия заявка обновляется (leads_pipeline.go), а
+// сообщение редактируется: кто взял, этап и оставшиеся кнопки.
+
+const (
+	leadActionTake      = "take"
+	leadActionContacted = "contacted"
+	leadActionSpam      = "spam"
+)
+
+// Secret token Bot API: 1–256 символов A-Z, a-z, 0-9, _ и -
+var telegramSecretRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+func telegramActionsEnabled() bool {
+	return os.Getenv("TELEGRAM_WEBHOOK_SECRET") != ""
+}
+
+// initTelegramWebhook проверяет настройки и регистрирует вебхук в фоне.
+func initTelegramWebhook() error {
+	secret := os.Getenv("TELEGRAM_WEBHOOK_SECRET")
+	hookURL := os.Getenv("TELEGRAM_WEBHOOK_URL")
+	if secret != "" && !telegramSecretRe.MatchString(secret) {
+		return errors.New("TELEGRAM_WEBHOOK_SECRET may contain only A-Z, a-z, 0-9, _ and - (up to 256 chars)")
+	}
+	if hookURL == "" {
+		return nil
+	}
+	if secret == "" {
+		return errors.New("TELEGRAM_WEBHOOK_URL requires TELEGRAM_WEBHOOK_SECRET")
+	}
+	go func() {
+		_, err := telegramCall("setWebhook", url.Values{
+			"url":             {hookURL},
+			"secret_token":    {secret},
+			"allowed_updates": {`["callback_query"]`},
+		})
+		if err != nil {
+			log.Println("[telegram] setWebhook failed:", err)
+			return
+		}
+		log.Println("[telegram] webhook registered:", hookURL)
+	}()
+	return nil
+}
+
+func leadCallbackData(id int64, action string) string {
+	return fmt.Sprintf("lead:%d:%s", id, action)
+}
+
+func parseLeadCallback(data string) (int64, string, bool) {
+	parts := strings.Split(data, ":")
+	if len(parts) != 3 || parts[0] != "lead" {
+		return 0, "", false
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, "", false
+	}
+	return id, parts[2], true
+}
+
+// leadKeyboard — кнопки, которые ещё имеют смысл для заявки в её текущем состоянии.
+func leadKeyboard(l *Lead) *telegramKeyboard {
+	kb := &telegramKeyboard{InlineKeyboard: [][]telegramButton{}}
+	if l.Spam {
+		return kb
+	}
+	var row []telegramButton
+	if l.AssigneeID == 0 {
+		row = append(row, telegramButton{Text: "🙋 Взять", CallbackData: leadCallbackData(l.ID, leadActionTake)})
+	}
+	if l.Status == leadNew {
+		row = append(row, telegramButton{Text: "📞 Связались", CallbackData: leadCallbackData(l.ID, leadActionContacted)})
+	}
+	row = append(row, telegramButton{Text: "🚫 Спам", CallbackData: leadCallbackData(l.ID, leadActionSpam)})
+	kb.InlineKeyboard = append(kb.InlineKeyboard, row)
+	return kb
+}
+
+// leadTelegramText — текст уведомления с текущим состоянием заявки.
+func leadTelegramText(l *Lead) string {
+	var lines []string
+	if l.Assignee != "" {
+		lines = append(lines, "👤 Взял: "+l.Assignee)
+	}
+	if l.Status != leadNew {
+		lines = append(lines, "Этап: "+l.Status)
+	}
+	if l.Spam {
+		lines = append(lines, "🚫 Спам")
+	}
+	if len(lines) == 0 {
+		return leadMessage(l)
+	}
+	return leadMessage(l) + "\n\n" + strings.Join(lines, "\n")
+}
+
+type telegramCallbackQuery struct {
+	ID   string `json:"id"`
+	From struct {
+		ID int64 `json:"id"`
+	} `json:"from"`
+	Message *struct {
+		MessageID int64 `json:"message_id"`
+		Chat      struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+	Data string `json:"data"`
+}
+
+type telegramUpdate struct {
+	UpdateID      int64                  `json:"update_id"`
+	CallbackQuery *telegramCallbackQuery `json:"callback_query"`
+}
+
+func telegramLinkedUser(telegramID int64) (*User, error) {
+	var u User
+	err := db.QueryRow("SELECT id, login, role, telegram_id FROM users WHERE telegram_id = ?", telegramID).
+		Scan(&u.ID, &u.Login, &u.Role, &u.TelegramID)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// answerLeadCallback отвечает на нажатие; ошибки Bot API только логируются.
+func answerLeadCallback(q *telegramCallbackQuery, text string, alert bool) {
+	if err := answerCallbackQuery(q.ID, text, alert); err != nil {
+		log.Println("[telegram] answerCallbackQuery:", err)
+	}
+}
+
+// handleLeadCallback выполняет нажатие кнопки. Ошибка — только от БД: тогда
+// вебхук отвечает 500, и Telegram пришлёт обновление ещё раз.
+func handleLeadCallback(q *telegramCallbackQuery) error {
+	id, action, ok := parseLeadCallback(q.Data)
+	if !ok {
+		answerLeadCallback(q, "Неизвестная кнопка", false)
+		return nil
+	}
+	u, err := telegramLinkedUser(q.From.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		answerLeadCallback(q, fmt.Sprintf("Telegram не привязан к пользователю админки. Ваш ID: %d", q.From.ID), true)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !hasPermission(u, permLeadsManage) {
+		answerLeadCallback(q, "Нет прав на работу с заявками", true)
+		return nil
+	}
+	l, err := getLead(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		answerLeadCallback(q, "Заявка не найдена", true)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	me := int64(u.ID)
+	var ch leadChange
+	switch action {
+	case leadActionTake:
+		if l.AssigneeID != 0 && l.AssigneeID != me {
+			answerLeadCallback(q, "Заявку уже взял "+l.Assignee, true)
+			return nil
+		}
+		ch.AssigneeID, ch.Take = &me, true
+	case leadActionContacted:
+		if l.Status == leadNew {
+			status := leadContacted
+			ch.Status = &status
+		}
+		// Кто связался, тот и ответственный, если заявку ещё никто не взял
+		if l.AssigneeID == 0 {
+			ch.AssigneeID, ch.Take = &me, true
+		}
+	case leadActionSpam:
+		spam := true
+		ch.Spam = &spam
+	default:
+		answerLeadCallback(q, "Неизвестная кнопка", false)
+		return nil
+	}
+	err = updateLead(id, u, ch)
+	if errors.Is(err, errLeadTaken) && action == leadActionContacted {
+		// Заявку только что взял другой — этап всё равно меняем
+		ch.AssigneeID, ch.Take = nil, false
+		err = updateLead(id, u, ch)
+	}
+	if errors.Is(err, errLeadTaken) {
+		if l, err = getLead(id); err != nil {
+			return err
+		}
+		answerLeadCallback(q, "Заявку уже взял "+l.Assignee, true)
+		return nil
+	}
+	if err != nil {
+		var bad badRequest
+		if errors.As(err, &bad) {
+			answerLeadCallback(q, bad.Error(), true)
+			return nil
+		}
+		return err
+	}
+	if l, err = getLead(id); err != nil {
+		return err
+	}
+	answerLeadCallback(q, "Готово", false)
+	if q.Message != nil {
+		if err := editTelegramMessage(q.Message.Chat.ID, q.Message.MessageID, leadTelegramText(l), leadKeyboard(l)); err != nil {
+			log.Printf("[telegram] edit message for lead %d: %v", id, err)
+		}
+	}
+	log.Printf("[telegram] lead %d: %s by %s", id, action, u.Login)
+	return nil
+}
+
+// POST /api/telegram/webhook
+func handleTelegramWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	secret := os.Getenv("TELEGRAM_WEBHOOK_SECRET")
+	got := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var upd telegramUpdate
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&upd); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	// Прочие обновления не нужны — отвечаем ok, чтобы Telegram их не повторял
+	if upd.CallbackQuery != nil {
+		if err := handleLeadCallback(upd.CallbackQuery); err != nil {
+			log.Println("[telegram] DB error:", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"ok"}`))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testWebhookSecret = "hook-secret_1"
+
+func createTelegramUser(t *testing.T, login, role string, telegramID int64) int {
+	t.Helper()
+	id, err := createUser(login, "password123", role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE users SET telegram_id = ? WHERE id = ?", telegramID, id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// postCallback отправляет на вебхук нажатие кнопки из сообщения 42 в чате 100.
+func postCallback(t *testing.T, secret string, from int64, data string) *httptest.ResponseRecorder {
+	t.Helper()
+	body := fmt.Sprintf(`{"update_id":1,"callback_query":{"id":"cb%d","from":{"id":%d},
+		"message":{"message_id":42,"chat":{"id":100}},"data":%q}}`, from, from, data)
+	r := httptest.NewRequest(http.MethodPost, "/api/telegram/webhook", strings.NewReader(body))
+	if secret != "" {
+		r.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+	}
+	w := httptest.NewRecorder()
+	handleTelegramWebhook(w, r)
+	return w
+}
+
+func TestTelegramWebhookRejectsWrongSecret(t *testing.T) {
+	setupTestDB(t)
+	bot := newFakeBotAPI(t)
+	t.Setenv("TELEGRAM_WEBHOOK_SECRET", testWebhookSecret)
+	createTelegramUser(t, "anna", roleSales, 501)
+	l := createTestLead(t)
+
+	for _, secret := range []string{"", "wrong"} {
+		if w := postCallback(t, secret, 501, leadCallbackData(l.ID, leadActionTake)); w.Code != http.StatusForbidden {
+			t.Errorf("secret %q: status %d, want 403", secret, w.Code)
+		}
+	}
+	if got, _ := getLead(l.ID); got.AssigneeID != 0 {
+		t.Errorf("lead assigned without a valid secret: %d", got.AssigneeID)
+	}
+	if len(bot.calls) != 0 {
+		t.Errorf("Bot API called %d time(s)", len(bot.calls))
+	}
+}
+
+func TestTelegramLeadActions(t *testing.T) {
+	setupTestDB(t)
+	bot := newFakeBotAPI(t)
+	t.Setenv("TELEGRAM_WEBHOOK_SECRET", testWebhookSecret)
+	anna := createTelegramUser(t, "anna", roleSales, 501)
+	createTelegramUser(t, "boris", roleSales, 502)
+	l := createTestLead(t)
+
+	if w := postCallback(t, testWebhookSecret, 501, leadCallbackData(l.ID, leadActionContacted)); w.Code != http.StatusOK {
+		t.Fatalf("contacted: status %d: %s", w.Code, w.Body)
+	}
+	got, _ := getLead(l.ID)
+	if got.Status != leadContacted || got.AssigneeID != int64(anna) {
+		t.Fatalf("after contacted: status %q, assignee %d", got.Status, got.AssigneeID)
+	}
+	edits := bot.callsTo("editMessageText")
+	if len(edits) != 1 {
+		t.Fatalf("editMessageText called %d time(s)", len(edits))
+	}
+	p := edits[0].Params
+	if p.Get("chat_id") != "100" || p.Get("message_id") != "42" {
+		t.Errorf("edited chat %s message %s", p.Get("chat_id"), p.Get("message_id"))
+	}
+	if text := p.Get("text"); !strings.Contains(text, "Взял: anna") || !strings.Contains(text, "Этап: contacted") {
+		t.Errorf("edited text %q", text)
+	}
+	var kb telegramKeyboard
+	if err := json.Unmarshal([]byte(p.Get("reply_markup")), &kb); err != nil {
+		t.Fatal(err)
+	}
+	if len(kb.InlineKeyboard) != 1 || len(kb.InlineKeyboard[0]) != 1 || kb.InlineKeyboard[0][0].CallbackData != leadCallbackData(l.ID, leadActionSpam) {
+		t.Errorf("keyboard after contacted: %+v", kb.InlineKeyboard)
+	}
+
+	// Заявку уже взяли — второй менеджер получает отказ, ответственный не меняется
+	if w := postCallback(t, testWebhookSecret, 502, leadCallbackData(l.ID, leadActionTake)); w.Code != http.StatusOK {
+		t.Fatalf("take: status %d", w.Code)
+	}
+	answers := bot.callsTo("answerCallbackQuery")
+	if last := answers[len(answers)-1].Params; last.Get("text") != "Заявку уже взял anna" || last.Get("show_alert") != "true" {
+		t.Errorf("answer %v", last)
+	}
+	if got, _ := getLead(l.ID); got.AssigneeID != int64(anna) {
+		t.Errorf("assignee changed to %d", got.AssigneeID)
+	}
+	if n := len(bot.callsTo("editMessageText")); n != 1 {
+		t.Errorf("message edited on a rejected take (%d edits)", n)
+	}
+}
+
+func TestTelegramUnlinkedUser(t *testing.T) {
+	setupTestDB(t)
+	bot := newFakeBotAPI(t)
+	t.Setenv("TELEGRAM_WEBHOOK_SECRET", testWebhookSecret)
+	l := createTestLead(t)
+
+	if w := postCallback(t, testWebhookSecret, 777, leadCallbackData(l.ID, leadActionTake)); w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	answers := bot.callsTo("answerCallbackQuery")
+	if len(answers) != 1 || !strings.Contains(answers[0].Params.Get("text"), "777") {
+		t.Errorf("answers %v", answers)
+	}
+}
+
+// Два «взять», прочитавших заявку свободной: проходит только первое.
+func TestUpdateLeadTakeIsConditional(t *testing.T) {
+	setupTestDB(t)
+	anna := createTelegramUser(t, "anna", roleSales, 501)
+	boris := createTelegramUser(t, "boris", roleSales, 502)
+	l := createTestLead(t)
+
+	annaID, borisID := int64(anna), int64(boris)
+	if err := updateLead(l.ID, &User{ID: anna}, leadChange{AssigneeID: &annaID, Take: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateLead(l.ID, &User{ID: boris}, leadChange{AssigneeID: &borisID, Take: true}); !errors.Is(err, errLeadTaken) {
+		t.Fatalf("second take: %v, want errLeadTaken", err)
+	}
+	if got, _ := getLead(l.ID); got.AssigneeID != annaID {
+		t.Errorf("assignee %d, want %d", got.AssigneeID, annaID)
+	}
+	// Повторное «взять» своей заявки не ошибка
+	if err := updateLead(l.ID, &User{ID: anna}, leadChange{AssigneeID: &annaID, Take: true}); err != nil {
+		t.Errorf("repeated take: %v", err)
+	}
+	// Без Take (PATCH из админки) переназначение разрешено
+	if err := updateLead(l.ID, &User{ID: anna}, leadChange{AssigneeID: &borisID}); err != nil {
+		t.Errorf("reassign: %v", err)
+	}
+}
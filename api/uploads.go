@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// --- UPLOADS ---
+// Тип файла определяется по содержимому (magic bytes), а не по имени и
+// Content-Type из формы: принимаются только растровые картинки из uploadTypes.
+// SVG и HTML отклоняются — они могут нести скрипты. Файлы адресуются по
+// содержимому: ключ в хранилище — {sha256}.{ext}, так что повторная загрузка тех же
+// байтов отдаёт уже существующий URL. Исходное имя остаётся в таблице media.
+
+const (
+	maxUploadFileSize = 10 << 20   // один файл
+	maxUploadPixels   = 50_000_000 // ширина*высота, защита от «распаковочных бомб»
+	uploadMemory      = 8 << 20    // остальное ParseMultipartForm держит во временных файлах
+)
+
+// uploadTypes: MIME по сигнатуре -> расширение сохранённого файла.
+var uploadTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// uploadError — отказ в приёме файла или формы, клиенту уходит как JSON.
+type uploadError struct {
+	Status  int    `json:"-"`
+	Message string `json:"error"`
+	Field   string `json:"field,omitempty"`
+	File    string `json:"file,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Limit   int64  `json:"limit,omitempty"`
+}
+
+func (e *uploadError) Error() string { return e.Message }
+
+// writeRequestError отвечает на ошибку разбора запроса: uploadError — JSON
+// с его статусом, остальное — 400 текстом, как раньше.
+func writeRequestError(w http.ResponseWriter, err error) {
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		writeJSON(w, ue.Status, ue)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// parseUploadForm разбирает multipart-форму. Лимит на весь запрос ставит
+// http.MaxBytesReader в обработчике; его срабатывание превращается в 413.
+func parseUploadForm(r *http.Request) error {
+	if err := r.ParseMultipartForm(uploadMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &uploadError{Status: http.StatusRequestEntityTooLarge, Message: "Request too large", Limit: tooLarge.Limit}
+		}
+		return badRequest("Invalid form")
+	}
+	return nil
+}
+
+// upload — проверенный, но ещё не сохранённый файл.
+type upload struct {
+	header        *multipart.FileHeader
+	contentType   string
+	width, height int
+}
+
+// checkUpload проверяет размер, сигнатуру и заголовок картинки.
+func checkUpload(field string, fh *multipart.FileHeader) (*upload, error) {
+	reject := func(status int, msg string) *uploadError {
+		return &uploadError{Status: status, Message: msg, Field: field, File: fh.Filename}
+	}
+	if fh.Size > maxUploadFileSize {
+		e := reject(http.StatusRequestEntityTooLarge, "File too large")
+		e.Limit = maxUploadFileSize
+		return nil, e
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, reject(http.StatusBadRequest, "Cannot read file")
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	typ := http.DetectContentType(head[:n])
+	if _, ok := uploadTypes[typ]; !ok {
+		e := reject(http.StatusUnsupportedMediaType, "Unsupported file type, allowed: JPEG, PNG, GIF, WebP")
+		e.Type = strings.TrimSuffix(typ, "; charset=utf-8")
+		return nil, e
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, reject(http.StatusBadRequest, "Cannot read file")
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, reject(http.StatusBadRequest, "Invalid image")
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxUploadPixels {
+		return nil, reject(http.StatusRequestEntityTooLarge, "Image dimensions too large")
+	}
+	return &upload{header: fh, contentType: typ, width: cfg.Width, height: cfg.Height}, nil
+}
+
+// checkFormUploads проверяет все файлы поля формы; больше max — ошибка.
+func checkFormUploads(r *http.Request, field string, max int) ([]*upload, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+	files := r.MultipartForm.File[field]
+	if len(files) > max {
+		return nil, &uploadError{Status: http.StatusBadRequest, Message: "Too many files", Field: field, Limit: int64(max)}
+	}
+	var out []*upload
+	for _, fh := range files {
+		u, err := checkUpload(field, fh)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// contentKey — ключ файла в хранилище по sha256 содержимого.
+func contentKey(hash, contentType string) string {
+	return hash + uploadTypes[contentType]
+}
+
+// existingUpload ищет уже загруженный файл с теми же байтами. Если на файл
+// никто не ссылается, его created_at сдвигается на сейчас: иначе GC может
+// удалить сироту до того, как syncMediaRefs запишет новую ссылку.
+func existingUpload(hash string) (string, bool) {
+	rows, err := db.Query("SELECT path FROM media WHERE hash = ? ORDER BY created_at", hash)
+	if err != nil {
+		return "", false
+	}
+	var paths []string
+	for rows.Next() {
+		var p string
+		if rows.Scan(&p) == nil {
+			paths = append(paths, p)
+		}
+	}
+	rows.Close()
+	for _, p := range paths {
+		if key, ok := storageKey(p); ok {
+			if found, err := store.Exists(key); err == nil && found {
+				if _, err := db.Exec(`UPDATE media SET created_at = ? WHERE path = ?
+					AND NOT EXISTS (SELECT 1 FROM media_refs r WHERE r.path = media.path)`, time.Now().Unix(), p); err != nil {
+					log.Println("Media touch error:", p, err)
+				}
+				return p, true
+			}
+		}
+	}
+	return "", false
+}
+
+// saveUpload кладёт файл в хранилище (storage.go) под ключом {sha256}.{ext} и
+// регистрирует его в media (media.go) вместе с исходным именем. Если такие
+// байты уже загружались, возвращается существующий URL и created=false.
+func saveUpload(u *upload) (urlPath string, created bool, err error) {
+	// Ключ известен только после хэширования: файл читается дважды
+	src, err := u.header.Open()
+	if err != nil {
+		return "", false, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, src)
+	src.Close()
+	if err != nil {
+		return "", false, err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	if p, ok := existingUpload(hash); ok {
+		return p, false, nil
+	}
+	src, err = u.header.Open()
+	if err != nil {
+		return "", false, err
+	}
+	defer src.Close()
+	key := contentKey(hash, u.contentType)
+	if err := store.Put(key, src, size, u.contentType); err != nil {
+		return "", false, err
+	}
+	urlPath = storageURL(key)
+	_, err = db.Exec(`INSERT OR IGNORE INTO media (path, original_name, content_type, size, width, height, hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		urlPath, u.header.Filename, u.contentType, size, u.width, u.height, hash, time.Now().Unix())
+	if err != nil {
+		return "", false, err
+	}
+	return urlPath, true, nil
+}
+
+// saveUploads сохраняет проверенные файлы; варианты (images.go) строятся
+// в фоне и только для новых.
+func saveUploads(ups []*upload) ([]string, error) {
+	var paths, created []string
+	for _, u := range ups {
+		p, isNew, err := saveUpload(u)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, p)
+		if isNew {
+			created = append(created, p)
+		}
+	}
+	queueImages(created)
+	return paths, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"testing"
+)
+
+// uploadHeader собирает multipart-форму с одним файлом и возвращает его заголовок.
+func uploadHeader(t *testing.T, name string, data []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("images", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+	form, err := multipart.NewReader(&body, mw.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["images"][0]
+}
+
+// pngWithSize — PNG 1x1, в заголовке которого записаны другие размеры.
+func pngWithSize(t *testing.T, w, h uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	// Сигнатура (8) + длина (4) + "IHDR" (4), дальше ширина и высота, CRC после 13 байт данных
+	binary.BigEndian.PutUint32(b[16:], w)
+	binary.BigEndian.PutUint32(b[20:], h)
+	binary.BigEndian.PutUint32(b[29:], crc32.ChecksumIEEE(b[12:29]))
+	return b
+}
+
+func TestCheckUpload(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	var pngData, jpgData, gifData bytes.Buffer
+	png.Encode(&pngData, img)
+	jpeg.Encode(&jpgData, img, nil)
+	gif.Encode(&gifData, img, nil)
+
+	for _, tc := range []struct {
+		name, file string
+		data       []byte
+		status     int // 0 — файл принят
+		typ        string
+	}{
+		{"png", "a.png", pngData.Bytes(), 0, "image/png"},
+		{"jpeg", "a.jpg", jpgData.Bytes(), 0, "image/jpeg"},
+		{"gif", "a.gif", gifData.Bytes(), 0, "image/gif"},
+		// Тип берётся из содержимого, а не из имени
+		{"png named jpg", "photo.jpg", pngData.Bytes(), 0, "image/png"},
+		{"html named png", "x.png", []byte("<!DOCTYPE html><script>alert(1)</script>"), http.StatusUnsupportedMediaType, "text/html"},
+		{"svg", "x.svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), http.StatusUnsupportedMediaType, ""},
+		{"pdf", "x.png", []byte("%PDF-1.4\n"), http.StatusUnsupportedMediaType, "application/pdf"},
+		{"empty", "x.png", nil, http.StatusUnsupportedMediaType, ""},
+		{"truncated png", "x.png", pngData.Bytes()[:20], http.StatusBadRequest, ""},
+		{"at pixel limit", "x.png", pngWithSize(t, 10000, 5000), 0, "image/png"},
+		{"pixel bomb", "x.png", pngWithSize(t, 10000, 5001), http.StatusRequestEntityTooLarge, ""},
+		{"huge width", "x.png", pngWithSize(t, 1<<30, 1), http.StatusRequestEntityTooLarge, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := checkUpload("images", uploadHeader(t, tc.file, tc.data))
+			if tc.status == 0 {
+				if err != nil {
+					t.Fatalf("rejected: %v", err)
+				}
+				if u.contentType != tc.typ {
+					t.Errorf("type %q, want %q", u.contentType, tc.typ)
+				}
+				return
+			}
+			var ue *uploadError
+			if !errors.As(err, &ue) {
+				t.Fatalf("err = %v, want uploadError", err)
+			}
+			if ue.Status != tc.status || ue.Field != "images" || ue.File != tc.file {
+				t.Errorf("error %+v, want status %d", ue, tc.status)
+			}
+			if tc.typ != "" && ue.Type != tc.typ {
+				t.Errorf("reported type %q, want %q", ue.Type, tc.typ)
+			}
+		})
+	}
+
+	u, err := checkUpload("images", uploadHeader(t, "a.png", pngData.Bytes()))
+	if err != nil || u.width != 40 || u.height != 30 {
+		t.Errorf("size %dx%d, err %v", u.width, u.height, err)
+	}
+
+	// Размер проверяется до чтения файла
+	_, err = checkUpload("images", &multipart.FileHeader{Filename: "big.png", Size: maxUploadFileSize + 1})
+	var ue *uploadError
+	if !errors.As(err, &ue) || ue.Status != http.StatusRequestEntityTooLarge || ue.Limit != maxUploadFileSize {
+		t.Errorf("oversized file: %v", err)
+	}
+}